@@ -12,8 +12,13 @@ func main() {
 	flags := pflag.NewFlagSet("kubectl-debug-me-maybe", pflag.ExitOnError)
 	pflag.CommandLine = flags
 
+	ctx, stop := cmd.SignalContext()
+
 	root := cmd.NewCmdSniff(genericclioptions.IOStreams{In: os.Stdin, Out: os.Stdout, ErrOut: os.Stderr})
-	if err := root.Execute(); err != nil {
+	err := root.ExecuteContext(ctx)
+	stop()
+
+	if err != nil {
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,230 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const uploadChunkSize = 1024 * 1024
+const uploadChunkAttempts = 3
+
+// chunkWriter describes a way of writing a file on a container from the stdin
+// of an exec session, one chunk at a time. Each chunk is either written to its
+// own part file, the parts being assembled once all are uploaded, or in place
+// at its offset, so that a failed chunk can be retried. Preparing, verifying
+// and launching dlv need a shell anyway.
+type chunkWriter struct {
+	name string
+	// command writes stdin to the part file, e.g. 'cat'
+	command string
+	// base64 encodes the chunks before sending them
+	base64 bool
+	// inPlace writes the chunks at their offset in the file with dd
+	inPlace bool
+}
+
+var chunkWriters = []chunkWriter{
+	{name: "cat", command: "cat"},
+	{name: "dd", inPlace: true},
+	{name: "base64", command: "base64 -d", base64: true},
+}
+
+func (w chunkWriter) probe() []string {
+	return []string{"/bin/sh", "-c", "command -v " + w.name}
+}
+
+func (w chunkWriter) chunk(remotePath string, index int) []string {
+	if w.inPlace {
+		// without conv=notrunc dd truncates the file at the chunk offset, so a
+		// retried chunk is written again from its start. ibs smaller than obs
+		// makes dd fill whole output blocks from the short reads of stdin.
+		return []string{"dd", "of=" + remotePath, "ibs=4096", fmt.Sprintf("obs=%d", uploadChunkSize), fmt.Sprintf("seek=%d", index)}
+	}
+
+	return []string{"/bin/sh", "-c", fmt.Sprintf("%s > %s.part.%05d", w.command, shellQuote(remotePath), index)}
+}
+
+func assemblePartsCommand(remotePath string) []string {
+	quoted := shellQuote(remotePath)
+	return []string{"/bin/sh", "-c", fmt.Sprintf("cat %s.part.* > %s && rm -f %s.part.*", quoted, quoted, quoted)}
+}
+
+func removePartsCommand(remotePath string) []string {
+	return []string{"/bin/sh", "-c", fmt.Sprintf("rm -f %s.part.*", shellQuote(remotePath))}
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'"'"'`) + "'"
+}
+
+// UploadChunked streams the local file to the container through the stdin of
+// exec sessions, using the first available writer among cat, dd and base64. It
+// neither needs tar nor curl on the container. The parts left by a previous
+// upload are removed first, and the ones of a failed upload on the way out.
+func (k *KubernetesApiServiceImpl) UploadChunked(ctx context.Context, localPath string, remotePath string, podName string, containerName string) (err error) {
+	log.Infof("uploading file: '%s' to '%s' on container: '%s' in chunks", localPath, remotePath, containerName)
+
+	writer, err := k.probeChunkWriter(ctx, podName, containerName)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("writing chunks on container with: '%s'", writer.name)
+
+	if err := k.executeUploadCommand(ctx, removePartsCommand(remotePath), nil, podName, containerName); err != nil {
+		return errors.Wrap(err, "failed to remove the chunks of a previous upload")
+	}
+
+	defer func() {
+		if err != nil {
+			cleanupCtx, cancel := cleanupContext(ctx)
+			defer cancel()
+
+			if err := k.executeUploadCommand(cleanupCtx, removePartsCommand(remotePath), nil, podName, containerName); err != nil {
+				log.WithError(err).Warnf("failed to remove the uploaded chunks of: '%s'", remotePath)
+			}
+		}
+	}()
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	progress := NewProgress(file, "uploading "+path.Base(localPath), info.Size(), k.progressOut)
+
+	buffer := make([]byte, uploadChunkSize)
+
+	for index := 0; ; index++ {
+		n, err := io.ReadFull(progress, buffer)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		chunk := buffer[:n]
+
+		if writer.base64 {
+			chunk = []byte(base64.StdEncoding.EncodeToString(chunk))
+		}
+
+		if err := k.uploadChunk(ctx, writer.chunk(remotePath, index), chunk, podName, containerName); err != nil {
+			return errors.Wrapf(err, "failed to upload chunk %d", index)
+		}
+
+		log.Debugf("uploaded chunk %d (%d bytes)", index, n)
+	}
+
+	progress.Done()
+
+	if !writer.inPlace {
+		if err := k.executeUploadCommand(ctx, assemblePartsCommand(remotePath), nil, podName, containerName); err != nil {
+			return errors.Wrap(err, "failed to assemble uploaded chunks")
+		}
+	}
+
+	if err := k.executeUploadCommand(ctx, []string{"chmod", "755", remotePath}, nil, podName, containerName); err != nil {
+		return errors.Wrap(err, "failed to mark the uploaded file as executable")
+	}
+
+	return nil
+}
+
+func (k *KubernetesApiServiceImpl) probeChunkWriter(ctx context.Context, podName string, containerName string) (chunkWriter, error) {
+	var tried []string
+
+	for _, writer := range chunkWriters {
+		exitCode, err := k.podExec(ctx, ExecCommandRequest{
+			KubeRequest: KubeRequest{
+				Clientset:  k.clientset,
+				RestConfig: k.restConfig,
+				Namespace:  k.targetNamespace,
+				Pod:        podName,
+				Container:  containerName,
+			},
+			Command: writer.probe(),
+			StdOut:  new(NopWriter),
+			StdErr:  new(NopWriter),
+		})
+		if err == nil && exitCode == 0 {
+			return writer, nil
+		}
+
+		log.Debugf("chunk writer '%s' is not available on container: '%s'", writer.name, containerName)
+		tried = append(tried, writer.name)
+	}
+
+	return chunkWriter{}, errors.Errorf("none of the chunk writers is available on container: '%s', tried: '%s'",
+		containerName, strings.Join(tried, "', '"))
+}
+
+func (k *KubernetesApiServiceImpl) uploadChunk(ctx context.Context, command []string, chunk []byte, podName string, containerName string) error {
+	var err error
+
+	for attempt := 1; attempt <= uploadChunkAttempts; attempt++ {
+		err = k.executeUploadCommand(ctx, command, chunk, podName, containerName)
+		if err == nil {
+			return nil
+		}
+
+		log.WithError(err).Warnf("chunk upload attempt %d/%d failed", attempt, uploadChunkAttempts)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * time.Second):
+		}
+	}
+
+	return err
+}
+
+func (k *KubernetesApiServiceImpl) executeUploadCommand(ctx context.Context, command []string, stdIn []byte, podName string, containerName string) error {
+	stdErr := new(Writer)
+
+	req := ExecCommandRequest{
+		KubeRequest: KubeRequest{
+			Clientset:  k.clientset,
+			RestConfig: k.restConfig,
+			Namespace:  k.targetNamespace,
+			Pod:        podName,
+			Container:  containerName,
+		},
+		Command: command,
+		StdOut:  stdErr,
+		StdErr:  stdErr,
+	}
+
+	if stdIn != nil {
+		req.StdIn = bytes.NewReader(stdIn)
+	}
+
+	exitCode, err := k.podExec(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	if exitCode != 0 {
+		return errors.Errorf("command: '%s' failed, exitCode: '%d', stdOut/stdErr: '%s'", command, exitCode, stdErr.Output)
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// localExec runs the exec commands on the local host, reporting only the
+// writers in available and failing the chunk attempts listed in failures
+// after half of their stdin was written.
+type localExec struct {
+	available map[string]bool
+	failures  map[string]int
+	commands  [][]string
+}
+
+func (l *localExec) exec(ctx context.Context, req ExecCommandRequest) (int, error) {
+	l.commands = append(l.commands, req.Command)
+
+	command := strings.Join(req.Command, " ")
+	if name, ok := strings.CutPrefix(command, "/bin/sh -c command -v "); ok && !l.available[name] {
+		return 1, nil
+	}
+
+	stdIn := req.StdIn
+	failed := false
+	for chunk, remaining := range l.failures {
+		if remaining > 0 && strings.Contains(command, chunk) {
+			l.failures[chunk]--
+			data, _ := io.ReadAll(stdIn)
+			stdIn = bytes.NewReader(data[:len(data)/2])
+			failed = true
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, req.Command[0], req.Command[1:]...)
+	cmd.Stdin = stdIn
+	cmd.Stdout = req.StdOut
+	cmd.Stderr = req.StdErr
+
+	err := cmd.Run()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if failed {
+		return 1, nil
+	}
+
+	return 0, nil
+}
+
+func TestUploadChunked(t *testing.T) {
+	local := filepath.Join(t.TempDir(), "dlv")
+
+	content := make([]byte, 2*uploadChunkSize+uploadChunkSize/2)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(local, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		available []string
+		failures  map[string]int
+		writer    string
+		wantErr   bool
+	}{
+		{name: "cat", available: []string{"cat", "dd", "base64"}, writer: "cat"},
+		{name: "dd", available: []string{"dd", "base64"}, writer: "dd"},
+		{name: "base64", available: []string{"base64"}, writer: "base64"},
+		{name: "cat resumes failed chunk", available: []string{"cat"}, writer: "cat",
+			failures: map[string]int{".part.00001": 1}},
+		{name: "dd resumes failed chunk", available: []string{"dd"}, writer: "dd",
+			failures: map[string]int{"seek=1": 1}},
+		{name: "base64 resumes failed chunk", available: []string{"base64"}, writer: "base64",
+			failures: map[string]int{".part.00002": 2}},
+		{name: "no writer", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			remote := filepath.Join(t.TempDir(), "dlv")
+
+			// parts left by a previous upload must not end up in the file
+			if err := os.WriteFile(remote+".part.00009", []byte("stale"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			l := &localExec{available: map[string]bool{}, failures: test.failures}
+			for _, name := range test.available {
+				l.available[name] = true
+			}
+
+			k := &KubernetesApiServiceImpl{podExec: l.exec, progressOut: io.Discard}
+
+			err := k.UploadChunked(context.Background(), local, remote, "pod", "container")
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("UploadChunked() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("UploadChunked() = %v", err)
+			}
+
+			uploaded, err := os.ReadFile(remote)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(uploaded, content) {
+				t.Errorf("uploaded file has %d bytes, want the %d bytes of the local file", len(uploaded), len(content))
+			}
+
+			if info, err := os.Stat(remote); err != nil || info.Mode().Perm() != 0755 {
+				t.Errorf("uploaded file mode = %v, %v, want 0755", info.Mode().Perm(), err)
+			}
+
+			if parts, _ := filepath.Glob(remote + ".part.*"); len(parts) != 0 {
+				t.Errorf("chunk parts left on container: %v", parts)
+			}
+
+			var writer chunkWriter
+			for _, w := range chunkWriters {
+				if w.name == test.writer {
+					writer = w
+				}
+			}
+
+			first := strings.Join(writer.chunk(remote, 0), " ")
+			used := false
+			for _, command := range l.commands {
+				if strings.Join(command, " ") == first {
+					used = true
+				}
+			}
+			if !used {
+				t.Errorf("first chunk not written with %q, commands: %q", first, l.commands)
+			}
+		})
+	}
+}
+
+func TestUploadChunkedRemovesPartsOnFailure(t *testing.T) {
+	local := filepath.Join(t.TempDir(), "dlv")
+	if err := os.WriteFile(local, make([]byte, uploadChunkSize+1), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	remote := filepath.Join(t.TempDir(), "dlv")
+
+	l := &localExec{available: map[string]bool{"cat": true},
+		failures: map[string]int{".part.00001": uploadChunkAttempts}}
+
+	k := &KubernetesApiServiceImpl{podExec: l.exec, progressOut: io.Discard}
+
+	if err := k.UploadChunked(context.Background(), local, remote, "pod", "container"); err == nil {
+		t.Fatal("UploadChunked() succeeded, want an error")
+	}
+
+	if parts, _ := filepath.Glob(remote + ".part.*"); len(parts) != 0 {
+		t.Errorf("chunk parts left after failed upload: %v", parts)
+	}
+}
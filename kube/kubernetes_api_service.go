@@ -8,36 +8,69 @@ import (
 	"io"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-	"k8s.io/utils/pointer"
+	"k8s.io/client-go/tools/portforward"
+	"strings"
 	"time"
 )
 
 type KubernetesApiService interface {
-	ExecuteCommand(podName string, containerName string, command []string, stdOut io.Writer) (int, error)
+	ExecuteCommand(ctx context.Context, podName string, containerName string, command []string, stdOut io.Writer) (int, error)
 
-	DeletePod(podName string) error
+	DeletePod(ctx context.Context, podName string) error
 
-	UploadFileTar(localPath string, remotePath string, podName string, containerName string) error
-	UploadThroughCurl(localPath string, remotePath string, podName string, containerName string) error
+	UploadFileTar(ctx context.Context, localPath string, remotePath string, podName string, containerName string) error
+	UploadThroughCurl(ctx context.Context, localPath string, remotePath string, podName string, containerName string, stager StagerConfig) error
+	UploadChunked(ctx context.Context, localPath string, remotePath string, podName string, containerName string) error
+	VerifyFile(ctx context.Context, localPath string, remotePath string, podName string, containerName string) error
+
+	EnsureEphemeralContainer(ctx context.Context, podName string, targetContainerName string, image string) (string, error)
+
+	ListStagerResources(ctx context.Context) ([]StagerResource, error)
+	DeleteStagerResource(ctx context.Context, kind string, namespace string, name string) error
+
+	// PortForward prepares a port-forward to the pod, stopped once ctx is done
+	PortForward(ctx context.Context, podName string, localPort int, remotePort int, readyCh chan struct{}, out io.Writer) (*portforward.PortForwarder, error)
+}
+
+const ephemeralContainerPrefix = "dmm-debugger-"
+
+// cleanupTimeout bounds the removal of what an upload created, so that an
+// unreachable API server doesn't keep dmm from exiting.
+const cleanupTimeout = time.Minute
+
+// cleanupContext returns the context removing what an upload created: not done
+// when ctx is, the upload being interrupted then, but bounded by
+// cleanupTimeout.
+func cleanupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), cleanupTimeout)
 }
 
 type KubernetesApiServiceImpl struct {
 	clientset       *kubernetes.Clientset
 	restConfig      *rest.Config
 	targetNamespace string
+	progressOut     io.Writer
+	// podExec runs the commands of the chunked uploads, PodExecuteCommand
+	// unless replaced by the tests
+	podExec func(ctx context.Context, req ExecCommandRequest) (int, error)
 }
 
+// NewKubernetesApiService returns the service for the namespace, drawing the
+// progress of the uploads on progressOut.
 func NewKubernetesApiService(clientset *kubernetes.Clientset,
-	restConfig *rest.Config, targetNamespace string) KubernetesApiService {
+	restConfig *rest.Config, targetNamespace string, progressOut io.Writer) KubernetesApiService {
 
 	return &KubernetesApiServiceImpl{clientset: clientset,
 		restConfig:      restConfig,
-		targetNamespace: targetNamespace}
+		targetNamespace: targetNamespace,
+		progressOut:     progressOut,
+		podExec:         PodExecuteCommand}
 }
 
-func (k *KubernetesApiServiceImpl) ExecuteCommand(podName string, containerName string, command []string, stdOut io.Writer) (int, error) {
+func (k *KubernetesApiServiceImpl) ExecuteCommand(ctx context.Context, podName string, containerName string, command []string, stdOut io.Writer) (int, error) {
 
 	log.Infof("executing command: '%s' on container: '%s', pod: '%s', namespace: '%s'", command, containerName, podName, k.targetNamespace)
 	stdErr := new(Writer)
@@ -55,7 +88,7 @@ func (k *KubernetesApiServiceImpl) ExecuteCommand(podName string, containerName
 		StdOut:  stdOut,
 	}
 
-	exitCode, err := PodExecuteCommand(executeDlvRequest)
+	exitCode, err := PodExecuteCommand(ctx, executeDlvRequest)
 	if err != nil {
 		log.WithError(err).Errorf("failed executing command: '%s', exitCode: '%d', stdErr: '%s'",
 			command, exitCode, stdErr.Output)
@@ -68,27 +101,49 @@ func (k *KubernetesApiServiceImpl) ExecuteCommand(podName string, containerName
 	return exitCode, err
 }
 
-func (k *KubernetesApiServiceImpl) DeletePod(podName string) error {
+func (k *KubernetesApiServiceImpl) PortForward(ctx context.Context, podName string, localPort int, remotePort int,
+	readyCh chan struct{}, out io.Writer) (*portforward.PortForwarder, error) {
+
+	log.Infof("forwarding local port: '%d' to port: '%d' of pod: '%s', namespace: '%s'",
+		localPort, remotePort, podName, k.targetNamespace)
+
+	return NewPodPortForwarder(PortForwardRequest{
+		KubeRequest: KubeRequest{
+			Clientset:  k.clientset,
+			RestConfig: k.restConfig,
+			Namespace:  k.targetNamespace,
+			Pod:        podName,
+		},
+		LocalPort:  localPort,
+		RemotePort: remotePort,
+		StopCh:     ctx.Done(),
+		ReadyCh:    readyCh,
+		StdOut:     out,
+		StdErr:     out,
+	})
+}
+
+func (k *KubernetesApiServiceImpl) DeletePod(ctx context.Context, podName string) error {
 
 	log.Infof("removing privileged pod: '%s'", podName)
 	defer log.Infof("privileged pod: '%s' removed", podName)
 
 	var gracePeriodTime int64 = 0
 
-	err := k.clientset.CoreV1().Pods(k.targetNamespace).Delete(context.Background(), podName, v1.DeleteOptions{
+	err := k.clientset.CoreV1().Pods(k.targetNamespace).Delete(ctx, podName, v1.DeleteOptions{
 		GracePeriodSeconds: &gracePeriodTime,
 	})
 
 	return err
 }
 
-func (k *KubernetesApiServiceImpl) checkIfFileExistOnPod(remotePath string, podName string, containerName string) (bool, error) {
+func (k *KubernetesApiServiceImpl) checkIfFileExistOnPod(ctx context.Context, remotePath string, podName string, containerName string) (bool, error) {
 	stdOut := new(Writer)
 	stdErr := new(Writer)
 
 	command := []string{"/bin/sh", "-c", fmt.Sprintf("test -f %s", remotePath)}
 
-	exitCode, err := k.ExecuteCommand(podName, containerName, command, stdOut)
+	exitCode, err := k.ExecuteCommand(ctx, podName, containerName, command, stdOut)
 	if err != nil {
 		return false, err
 	}
@@ -106,9 +161,10 @@ func (k *KubernetesApiServiceImpl) checkIfFileExistOnPod(remotePath string, podN
 	return true, nil
 }
 
-func (k *KubernetesApiServiceImpl) UploadThroughCurl(localPath string, remotePath string, podName string, containerName string) error {
-	log.Infof("Checking if file exists on the pod: '%s'", remotePath)
-	isExist, err := k.checkIfFileExistOnPod(remotePath, podName, containerName)
+func (k *KubernetesApiServiceImpl) UploadFileTar(ctx context.Context, localPath string, remotePath string, podName string, containerName string) error {
+	log.Infof("uploading file: '%s' to '%s' on container: '%s'", localPath, remotePath, containerName)
+
+	isExist, err := k.checkIfFileExistOnPod(ctx, remotePath, podName, containerName)
 	if err != nil {
 		return err
 	}
@@ -118,128 +174,9 @@ func (k *KubernetesApiServiceImpl) UploadThroughCurl(localPath string, remotePat
 		return nil
 	}
 
-	// 1. Launch a python pod w/ service (http server)
-	log.Infof("Create file serving pod")
-	pod, err := k.clientset.CoreV1().Pods(k.targetNamespace).Create(context.Background(), &corev1.Pod{
-		ObjectMeta: v1.ObjectMeta{
-			GenerateName: "dmm-stager-",
-			Namespace:    k.targetNamespace,
-			Labels: map[string]string{
-				"app": "dmm-stager",
-			},
-		},
-		Spec: corev1.PodSpec{
-			Containers: []corev1.Container{
-				{
-					Name:  "stager",
-					Image: "docker.io/library/python:latest",
-					Command: []string{
-						"python3",
-						"-m",
-						"http.server",
-					},
-					WorkingDir: "/tmp",
-					Ports: []corev1.ContainerPort{
-						{
-							Protocol:      "TCP",
-							ContainerPort: 8000,
-						},
-					},
-					SecurityContext: &corev1.SecurityContext{
-						AllowPrivilegeEscalation: pointer.Bool(false),
-						Capabilities: &corev1.Capabilities{
-							Drop: []corev1.Capability{
-								"All",
-							},
-						},
-						RunAsNonRoot: pointer.Bool(true),
-						SeccompProfile: &corev1.SeccompProfile{
-							Type: "RuntimeDefault",
-						},
-					},
-				},
-			},
-		},
-	}, v1.CreateOptions{})
-	if err != nil {
-		log.WithError(err).Errorf("failed to create pod")
-		return err
-	}
-
-	defer func() {
-		err := k.clientset.CoreV1().Pods(k.targetNamespace).Delete(context.Background(), pod.Name, v1.DeleteOptions{})
-		if err != nil {
-			log.WithError(err).Errorf("failed to delete stager pod")
-		} else {
-			log.Infof("stager deleted pod")
-		}
-	}()
-
-	log.Infof("Waiting for staging pod to become ready")
-
-	i := 0
-
-	for ; i < 10; i++ {
-		time.Sleep(time.Second)
-		stagerPod, err := k.clientset.CoreV1().Pods(k.targetNamespace).Get(context.Background(), pod.Name, v1.GetOptions{})
-		if err != nil {
-			return err
-		}
-
-		if stagerPod.Status.Phase == corev1.PodRunning {
-			log.Infof("Stager pod is running")
-			i = 0
-			break
-		}
-	}
-
-	if i == 10 {
-		return errors.New("stager pod is not running, something went wrong, exiting")
-	}
-
-	log.Infof("Creating service for staging pod")
-	svc, err := k.clientset.CoreV1().Services(k.targetNamespace).Create(context.Background(), &corev1.Service{
-		ObjectMeta: v1.ObjectMeta{
-			GenerateName: "dmm-stager-",
-			Namespace:    k.targetNamespace,
-		},
-		Spec: corev1.ServiceSpec{
-			Ports: []corev1.ServicePort{
-				{
-					Protocol: "TCP",
-					Port:     8000,
-				},
-			},
-			Selector: map[string]string{
-				"app": "dmm-stager",
-			},
-		},
-	}, v1.CreateOptions{})
-
-	defer func() {
-		err := k.clientset.CoreV1().Services(k.targetNamespace).Delete(context.Background(), svc.Name, v1.DeleteOptions{})
-		if err != nil {
-			log.WithError(err).Errorf("failed to delete stager service")
-		} else {
-			log.Infof("stager deleted service")
-		}
-	}()
-
-	stagingDebuggerUrl := fmt.Sprintf("http://%s.%s.svc.cluster.local:8000/debugger", svc.Name, k.targetNamespace)
-	log.Infof("The staged debugger is available at: %s", stagingDebuggerUrl)
-
-	// 2. Copy the debugger to the pod
-	log.Infof("Uploading debugger to staging pod")
-	err = k.UploadFileTar(localPath, "debugger", pod.Name, "stager")
-	if err != nil {
-		log.WithError(err).Errorf("failed to upload debugger to stager pod")
-		return err
-	}
+	log.Infof("file not found at: '%s', starting to upload", remotePath)
 
-	// 3. Curl the debugger onto the pod to debug
-	log.Infof("Retrieving the debugger from the staging pod")
-	stdErr := new(Writer)
-	exitCode, err := PodExecuteCommand(ExecCommandRequest{
+	req := UploadFileRequest{
 		KubeRequest: KubeRequest{
 			Clientset:  k.clientset,
 			RestConfig: k.restConfig,
@@ -247,93 +184,108 @@ func (k *KubernetesApiServiceImpl) UploadThroughCurl(localPath string, remotePat
 			Pod:        podName,
 			Container:  containerName,
 		},
-		Command: []string{
-			"curl",
-			"-o", remotePath,
-			stagingDebuggerUrl,
-		},
-		StdIn:  nil,
-		StdOut: stdErr,
-		StdErr: stdErr,
-	})
-	if err != nil {
-		log.WithError(err).Errorf("failed to curl the staged debugger: exitCode: '%d', stdOut/stdErr: '%s'", exitCode, stdErr.Output)
-		return err
+		Src:         localPath,
+		Dst:         remotePath,
+		ProgressOut: k.progressOut,
 	}
 
-	// 4. Set the debugger as executable
-	log.Infof("Setting the debugger as executable")
-	exitCodeBis, err := PodExecuteCommand(ExecCommandRequest{
-		KubeRequest: KubeRequest{
-			Clientset:  k.clientset,
-			RestConfig: k.restConfig,
-			Namespace:  k.targetNamespace,
-			Pod:        podName,
-			Container:  containerName,
-		},
-		Command: []string{
-			"chmod",
-			"+x", remotePath,
-		},
-		StdIn:  nil,
-		StdOut: stdErr,
-		StdErr: stdErr,
-	})
+	exitCode, err := PodUploadFile(ctx, req)
+	if err != nil || exitCode != 0 {
+		return errors.Wrapf(err, "upload file failed, exitCode: %d", exitCode)
+	}
+
+	log.Info("verifying file uploaded successfully")
+
+	isExist, err = k.checkIfFileExistOnPod(ctx, remotePath, podName, containerName)
 	if err != nil {
-		log.WithError(err).Errorf("Failed to mark the debugger as executable: exitCode: '%d', stdOut/stdErr: '%s'", exitCodeBis, stdErr.Output)
 		return err
 	}
 
-	log.Infof("Debugger uploaded on the debugger pod")
+	if !isExist {
+		log.Error("failed to upload file.")
+		return errors.New("couldn't locate file on pod after upload done")
+	}
+
+	log.Info("file uploaded successfully")
 
 	return nil
 }
 
-func (k *KubernetesApiServiceImpl) UploadFileTar(localPath string, remotePath string, podName string, containerName string) error {
-	log.Infof("uploading file: '%s' to '%s' on container: '%s'", localPath, remotePath, containerName)
-
-	isExist, err := k.checkIfFileExistOnPod(remotePath, podName, containerName)
+// EnsureEphemeralContainer returns the name of a running debugger ephemeral
+// container sharing the process namespace of the target container, creating it
+// when needed. Ephemeral containers cannot be removed from a pod, so an
+// existing debugger container is reused across sessions.
+func (k *KubernetesApiServiceImpl) EnsureEphemeralContainer(ctx context.Context, podName string, targetContainerName string, image string) (string, error) {
+	pod, err := k.clientset.CoreV1().Pods(k.targetNamespace).Get(ctx, podName, v1.GetOptions{})
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	if isExist {
-		log.Info("file was already found on remote pod")
-		return nil
+	for _, container := range pod.Spec.EphemeralContainers {
+		if strings.HasPrefix(container.Name, ephemeralContainerPrefix) &&
+			container.TargetContainerName == targetContainerName && container.Image == image &&
+			isEphemeralContainerRunning(pod, container.Name) {
+			log.Infof("reusing debugger ephemeral container: '%s'", container.Name)
+			return container.Name, nil
+		}
 	}
 
-	log.Infof("file not found at: '%s', starting to upload", remotePath)
+	containerName := ephemeralContainerPrefix + rand.String(5)
 
-	req := UploadFileRequest{
-		KubeRequest: KubeRequest{
-			Clientset:  k.clientset,
-			RestConfig: k.restConfig,
-			Namespace:  k.targetNamespace,
-			Pod:        podName,
-			Container:  containerName,
+	log.Infof("adding debugger ephemeral container: '%s' (image: '%s') targeting container: '%s'",
+		containerName, image, targetContainerName)
+
+	pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name:    containerName,
+			Image:   image,
+			Command: []string{"sleep", "infinity"},
+			SecurityContext: &corev1.SecurityContext{
+				Capabilities: &corev1.Capabilities{
+					Add: []corev1.Capability{
+						"SYS_PTRACE",
+					},
+				},
+			},
 		},
-		Src: localPath,
-		Dst: remotePath,
-	}
+		TargetContainerName: targetContainerName,
+	})
 
-	exitCode, err := PodUploadFile(req)
-	if err != nil || exitCode != 0 {
-		return errors.Wrapf(err, "upload file failed, exitCode: %d", exitCode)
+	_, err = k.clientset.CoreV1().Pods(k.targetNamespace).UpdateEphemeralContainers(ctx, podName, pod, v1.UpdateOptions{})
+	if err != nil {
+		log.WithError(err).Errorf("failed to add ephemeral container")
+		return "", err
 	}
 
-	log.Info("verifying file uploaded successfully")
+	log.Infof("Waiting for debugger ephemeral container to start")
 
-	isExist, err = k.checkIfFileExistOnPod(remotePath, podName, containerName)
-	if err != nil {
-		return err
-	}
+	for i := 0; i < 60; i++ {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(time.Second):
+		}
 
-	if !isExist {
-		log.Error("failed to upload file.")
-		return errors.New("couldn't locate file on pod after upload done")
+		pod, err = k.clientset.CoreV1().Pods(k.targetNamespace).Get(ctx, podName, v1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+
+		if isEphemeralContainerRunning(pod, containerName) {
+			log.Infof("debugger ephemeral container is running")
+			return containerName, nil
+		}
 	}
 
-	log.Info("file uploaded successfully")
+	return "", errors.Errorf("debugger ephemeral container: '%s' is not running, something went wrong, exiting", containerName)
+}
 
-	return nil
+func isEphemeralContainerRunning(pod *corev1.Pod, containerName string) bool {
+	for _, status := range pod.Status.EphemeralContainerStatuses {
+		if status.Name == containerName {
+			return status.State.Running != nil
+		}
+	}
+
+	return false
 }
@@ -2,6 +2,7 @@ package kube
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"os"
 	"path"
@@ -35,6 +36,9 @@ type UploadFileRequest struct {
 	KubeRequest
 	Src string
 	Dst string
+	// ProgressOut is where the progress of the upload is drawn, when it is a
+	// terminal
+	ProgressOut io.Writer
 }
 
 func (w *NopWriter) Write(p []byte) (n int, err error) {
@@ -56,30 +60,40 @@ type Writer struct {
 	Output string
 }
 
-func PodUploadFile(req UploadFileRequest) (int, error) {
+// PodUploadFile streams the file from disk to the container through tar,
+// gzipped when the container's tar supports it.
+func PodUploadFile(ctx context.Context, req UploadFileRequest) (int, error) {
 	stdOut := new(Writer)
 	stdErr := new(Writer)
 
 	log.Debugf("uploading file from: '%s' to '%s'", req.Src, req.Dst)
 
-	fileContent, err := os.ReadFile(req.Src)
+	file, err := os.Open(req.Src)
 	if err != nil {
 		return 0, err
 	}
+	defer file.Close()
 
-	log.Debugf("read '%s' to memory, file size: '%d'", req.Src, len(fileContent))
-
-	destFileName := path.Base(req.Dst)
-	tarFile, err := WrapAsTar(destFileName, fileContent)
+	info, err := file.Stat()
 	if err != nil {
 		return 0, err
 	}
 
-	log.Debugf("formatted '%s' as tar, tar size: '%d'", req.Src, len(tarFile))
+	compress := tarSupportsGzip(ctx, req.KubeRequest)
+
+	log.Debugf("streaming '%s' as tar, file size: '%d', gzip: '%t'", req.Src, info.Size(), compress)
+
+	progress := NewProgress(file, "uploading "+path.Base(req.Src), info.Size(), req.ProgressOut)
+	defer progress.Done()
 
-	stdIn := bytes.NewReader(tarFile)
+	// the archive isn't entirely read when tar fails
+	stdIn := StreamAsTar(path.Base(req.Dst), progress, info.Size(), compress)
+	defer stdIn.Close()
 
 	tarCmd := []string{"tar", "-xf", "-"}
+	if compress {
+		tarCmd = []string{"tar", "-xzf", "-"}
+	}
 
 	destDir := path.Dir(req.Dst)
 	if len(destDir) > 0 {
@@ -89,20 +103,14 @@ func PodUploadFile(req UploadFileRequest) (int, error) {
 	log.Debugf("executing tar: '%v'", tarCmd)
 
 	execTarRequest := ExecCommandRequest{
-		KubeRequest: KubeRequest{
-			Clientset:  req.Clientset,
-			RestConfig: req.RestConfig,
-			Namespace:  req.Namespace,
-			Pod:        req.Pod,
-			Container:  req.Container,
-		},
-		Command: tarCmd,
-		StdIn:   stdIn,
-		StdOut:  stdOut,
-		StdErr:  stdErr,
+		KubeRequest: req.KubeRequest,
+		Command:     tarCmd,
+		StdIn:       stdIn,
+		StdOut:      stdOut,
+		StdErr:      stdErr,
 	}
 
-	exitCode, err := PodExecuteCommand(execTarRequest)
+	exitCode, err := PodExecuteCommand(ctx, execTarRequest)
 
 	log.Debugf("done uploading file, exitCode: '%d', stdOut: '%s', stdErr: '%s'",
 		exitCode, stdOut.Output, stdErr.Output)
@@ -110,7 +118,28 @@ func PodUploadFile(req UploadFileRequest) (int, error) {
 	return exitCode, err
 }
 
-func PodExecuteCommand(req ExecCommandRequest) (int, error) {
+// tarSupportsGzip tells whether the tar of the container extracts gzipped
+// archives, by listing an empty one with 'tar -tzf -'.
+func tarSupportsGzip(ctx context.Context, req KubeRequest) bool {
+	archive, err := emptyGzipTar()
+	if err != nil {
+		return false
+	}
+
+	exitCode, err := PodExecuteCommand(ctx, ExecCommandRequest{
+		KubeRequest: req,
+		Command:     []string{"tar", "-tzf", "-"},
+		StdIn:       bytes.NewReader(archive),
+		StdOut:      new(NopWriter),
+		StdErr:      new(NopWriter),
+	})
+
+	return err == nil && exitCode == 0
+}
+
+// PodExecuteCommand runs a command in a container and returns its exit code,
+// the command is interrupted once ctx is done.
+func PodExecuteCommand(ctx context.Context, req ExecCommandRequest) (int, error) {
 
 	execRequest := req.Clientset.CoreV1().RESTClient().Post().
 		Resource("pods").
@@ -131,7 +160,7 @@ func PodExecuteCommand(req ExecCommandRequest) (int, error) {
 		return 0, err
 	}
 
-	err = exec.Stream(remotecommand.StreamOptions{
+	err = exec.StreamWithContext(ctx, remotecommand.StreamOptions{
 		Stdin:  req.StdIn,
 		Stdout: req.StdOut,
 		Stderr: req.StdErr,
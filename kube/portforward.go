@@ -0,0 +1,42 @@
+package kube
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+type PortForwardRequest struct {
+	KubeRequest
+	LocalPort  int
+	RemotePort int
+	StopCh     <-chan struct{}
+	ReadyCh    chan struct{}
+	StdOut     io.Writer
+	StdErr     io.Writer
+}
+
+// NewPodPortForwarder prepares a port-forward from localhost to the pod, it is
+// started by calling ForwardPorts on the returned forwarder.
+func NewPodPortForwarder(req PortForwardRequest) (*portforward.PortForwarder, error) {
+	transport, upgrader, err := spdy.RoundTripperFor(req.RestConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	portForwardUrl := req.Clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(req.Pod).
+		Namespace(req.Namespace).
+		SubResource("portforward").
+		URL()
+
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, "POST", portForwardUrl)
+
+	ports := []string{fmt.Sprintf("%d:%d", req.LocalPort, req.RemotePort)}
+
+	return portforward.NewOnAddresses(dialer, []string{"localhost"}, ports, req.StopCh, req.ReadyCh, req.StdOut, req.StdErr)
+}
@@ -0,0 +1,100 @@
+package kube
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const progressInterval = 200 * time.Millisecond
+const progressBarWidth = 30
+
+// Progress wraps the reader of an uploaded file to draw a progress bar, with
+// the throughput and the estimated time left, on the given output when it is
+// a terminal.
+type Progress struct {
+	reader     io.Reader
+	name       string
+	total      int64
+	read       int64
+	start      time.Time
+	lastRender time.Time
+	out        io.Writer
+}
+
+func NewProgress(reader io.Reader, name string, total int64, out io.Writer) *Progress {
+	progress := &Progress{reader: reader, name: name, total: total, start: time.Now()}
+
+	if file, ok := out.(*os.File); ok {
+		if info, err := file.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			progress.out = file
+		}
+	}
+
+	return progress
+}
+
+func (p *Progress) Read(b []byte) (int, error) {
+	n, err := p.reader.Read(b)
+	p.read += int64(n)
+
+	if time.Since(p.lastRender) >= progressInterval {
+		p.render()
+	}
+
+	return n, err
+}
+
+// Done draws the progress bar a last time and ends its line.
+func (p *Progress) Done() {
+	if p.out == nil {
+		return
+	}
+
+	p.render()
+	_, _ = fmt.Fprintln(p.out)
+}
+
+func (p *Progress) render() {
+	p.lastRender = time.Now()
+
+	if p.out == nil || p.total <= 0 {
+		return
+	}
+
+	ratio := float64(p.read) / float64(p.total)
+	if ratio > 1 {
+		ratio = 1
+	}
+
+	filled := int(ratio * progressBarWidth)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+
+	elapsed := time.Since(p.start).Seconds()
+	var throughput float64
+	if elapsed > 0 {
+		throughput = float64(p.read) / elapsed
+	}
+
+	eta := "--"
+	if throughput > 0 {
+		eta = time.Duration(float64(p.total-p.read) / throughput * float64(time.Second)).Round(time.Second).String()
+	}
+
+	_, _ = fmt.Fprintf(p.out, "\r%s [%s] %3.0f%% %s/%s %s/s ETA %s  ",
+		p.name, bar, ratio*100, formatBytes(float64(p.read)), formatBytes(float64(p.total)), formatBytes(throughput), eta)
+}
+
+func formatBytes(bytes float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+
+	unit := 0
+	for bytes >= 1024 && unit < len(units)-1 {
+		bytes /= 1024
+		unit++
+	}
+
+	return fmt.Sprintf("%.1f%s", bytes, units[unit])
+}
@@ -0,0 +1,470 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/utils/pointer"
+)
+
+const PythonStagerImage = "docker.io/library/python:latest"
+const BuiltinStagerImage = "docker.io/library/busybox:latest"
+
+// BuiltinStagerCommand is the hidden subcommand of the plugin serving the files
+// of a directory over HTTP, run in the stager pod by the builtin stager.
+const BuiltinStagerCommand = "stager-serve"
+
+const stagerPort = 8000
+const stagerDirectory = "/tmp"
+const stagerContainerName = "stager"
+const builtinStagerPath = stagerDirectory + "/dmm-stager"
+
+// DefaultStagerUser is the uid the stager pod runs as unless specified, the
+// stager images running as root by default, which RunAsNonRoot refuses.
+const DefaultStagerUser = 65534
+
+// StagerConfig describes the stager pod serving the debugger to the pod to
+// debug for the stager upload method.
+type StagerConfig struct {
+	Image            string
+	ImagePullSecrets []string
+	NodeSelector     map[string]string
+	Tolerations      []corev1.Toleration
+	Resources        corev1.ResourceRequirements
+	RunAsUser        *int64
+	Timeout          time.Duration
+	// Session and Owner label the stager resources, for them to be found by
+	// the garbage collector when they outlive the upload
+	Session string
+	Owner   string
+	// NetworkPolicy creates a network policy allowing only the pod to debug
+	// to reach the stager pod
+	NetworkPolicy bool
+	// Builtin serves the debugger with the plugin binary itself instead of
+	// python, so that the stager image only needs a shell
+	Builtin bool
+}
+
+// ParseStagerConfig builds the stager configuration from the user specified
+// values: node selectors as key=value, tolerations as key[=value]:effect (or
+// key:Exists to tolerate any value) and limits as quantities.
+func ParseStagerConfig(image string, pullSecrets []string, nodeSelectors []string, tolerations []string,
+	cpuLimit string, memoryLimit string, runAsUser int64, timeout time.Duration, builtin bool) (StagerConfig, error) {
+
+	config := StagerConfig{Image: image, ImagePullSecrets: pullSecrets, Timeout: timeout, Builtin: builtin}
+
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultStagerTimeout
+	}
+
+	if config.Image == "" {
+		config.Image = PythonStagerImage
+		if builtin {
+			config.Image = BuiltinStagerImage
+		}
+	}
+
+	for _, nodeSelector := range nodeSelectors {
+		key, value, found := strings.Cut(nodeSelector, "=")
+		if !found || key == "" {
+			return StagerConfig{}, errors.Errorf("invalid stager node selector: '%s', expected key=value", nodeSelector)
+		}
+
+		if config.NodeSelector == nil {
+			config.NodeSelector = map[string]string{}
+		}
+		config.NodeSelector[key] = value
+	}
+
+	for _, toleration := range tolerations {
+		parsed, err := parseToleration(toleration)
+		if err != nil {
+			return StagerConfig{}, err
+		}
+		config.Tolerations = append(config.Tolerations, parsed)
+	}
+
+	limits := corev1.ResourceList{}
+	for name, value := range map[corev1.ResourceName]string{corev1.ResourceCPU: cpuLimit, corev1.ResourceMemory: memoryLimit} {
+		if value == "" {
+			continue
+		}
+
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return StagerConfig{}, errors.Wrapf(err, "invalid stager %s limit: '%s'", name, value)
+		}
+		limits[name] = quantity
+	}
+
+	if len(limits) > 0 {
+		config.Resources = corev1.ResourceRequirements{Limits: limits, Requests: limits}
+	}
+
+	if runAsUser == 0 {
+		runAsUser = DefaultStagerUser
+	}
+	config.RunAsUser = pointer.Int64(runAsUser)
+
+	if builtin {
+		// the stager runs the plugin binary, which must fit the node
+		if runtime.GOOS != "linux" {
+			return StagerConfig{}, errors.Errorf("the builtin stager runs the plugin binary in the stager pod, which requires a linux build of the plugin, not %s", runtime.GOOS)
+		}
+
+		if config.NodeSelector == nil {
+			config.NodeSelector = map[string]string{}
+		}
+		if _, ok := config.NodeSelector[corev1.LabelArchStable]; !ok {
+			config.NodeSelector[corev1.LabelArchStable] = runtime.GOARCH
+		}
+	}
+
+	return config, nil
+}
+
+func parseToleration(value string) (corev1.Toleration, error) {
+	keyValue, effect, found := strings.Cut(value, ":")
+	if !found || keyValue == "" {
+		return corev1.Toleration{}, errors.Errorf("invalid stager toleration: '%s', expected key[=value]:effect", value)
+	}
+
+	toleration := corev1.Toleration{Operator: corev1.TolerationOpExists}
+
+	key, tolerationValue, hasValue := strings.Cut(keyValue, "=")
+	toleration.Key = key
+	if hasValue {
+		toleration.Operator = corev1.TolerationOpEqual
+		toleration.Value = tolerationValue
+	}
+
+	switch corev1.TaintEffect(effect) {
+	case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+		toleration.Effect = corev1.TaintEffect(effect)
+	case "Exists", "":
+		// any effect
+	default:
+		return corev1.Toleration{}, errors.Errorf("invalid stager toleration effect: '%s'", effect)
+	}
+
+	return toleration, nil
+}
+
+func (k *KubernetesApiServiceImpl) UploadThroughCurl(ctx context.Context, localPath string, remotePath string, podName string, containerName string, stager StagerConfig) error {
+	log.Infof("Checking if file exists on the pod: '%s'", remotePath)
+	isExist, err := k.checkIfFileExistOnPod(ctx, remotePath, podName, containerName)
+	if err != nil {
+		return err
+	}
+
+	if isExist {
+		log.Info("file was already found on remote pod")
+		return nil
+	}
+
+	target, err := k.clientset.CoreV1().Pods(k.targetNamespace).Get(ctx, podName, v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	// the stager of this session only, other sessions may run in the namespace
+	if stager.Session == "" {
+		stager.Session = rand.String(8)
+	}
+
+	k.checkNetworkPolicies(ctx, target, stager)
+
+	if stager.NetworkPolicy {
+		log.Infof("Creating network policy allowing pod: '%s' to reach the staging pod", podName)
+		policy, err := stagerNetworkPolicy(k.targetNamespace, stager, target)
+		if err != nil {
+			return err
+		}
+
+		policy, err = k.clientset.NetworkingV1().NetworkPolicies(k.targetNamespace).Create(ctx, policy, v1.CreateOptions{})
+		if err != nil {
+			return errors.Wrap(err, "failed to create the stager network policy")
+		}
+
+		// deleted even when the upload is interrupted
+		defer func() {
+			cleanupCtx, cancel := cleanupContext(ctx)
+			defer cancel()
+
+			err := k.clientset.NetworkingV1().NetworkPolicies(k.targetNamespace).Delete(cleanupCtx, policy.Name, v1.DeleteOptions{})
+			if err != nil {
+				log.WithError(err).Errorf("failed to delete stager network policy")
+			} else {
+				log.Infof("stager deleted network policy")
+			}
+		}()
+	}
+
+	// 1. Launch a stager pod w/ service (http server)
+	log.Infof("Create file serving pod (image: '%s', builtin: '%t', session: '%s')", stager.Image, stager.Builtin, stager.Session)
+	pod, err := k.clientset.CoreV1().Pods(k.targetNamespace).Create(ctx, stagerPod(k.targetNamespace, stager), v1.CreateOptions{})
+	if permission, denied := rbacDenial(err); denied {
+		return errors.Wrapf(err, "not allowed to %s in namespace: '%s', ask a cluster administrator for the permission", permission, k.targetNamespace)
+	}
+	if apierrors.IsForbidden(err) || apierrors.IsInvalid(err) {
+		return errors.Wrap(err, "the stager pod was denied, check the admission policies of the namespace")
+	}
+	if err != nil {
+		log.WithError(err).Errorf("failed to create pod")
+		return err
+	}
+
+	defer func() {
+		cleanupCtx, cancel := cleanupContext(ctx)
+		defer cancel()
+
+		err := k.clientset.CoreV1().Pods(k.targetNamespace).Delete(cleanupCtx, pod.Name, v1.DeleteOptions{})
+		if err != nil {
+			log.WithError(err).Errorf("failed to delete stager pod")
+		} else {
+			log.Infof("stager deleted pod")
+		}
+	}()
+
+	log.Infof("Waiting for staging pod to run (timeout: %s)", stager.Timeout)
+
+	if err := k.waitForStagerPod(ctx, pod.Name, stager.Timeout); err != nil {
+		return err
+	}
+
+	log.Infof("Creating service for staging pod")
+	svc, err := k.clientset.CoreV1().Services(k.targetNamespace).Create(ctx, &corev1.Service{
+		ObjectMeta: stagerObjectMeta(k.targetNamespace, stager),
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{
+					Protocol: "TCP",
+					Port:     stagerPort,
+				},
+			},
+			Selector: map[string]string{SessionLabel: stager.Session},
+		},
+	}, v1.CreateOptions{})
+	if err != nil {
+		log.WithError(err).Errorf("failed to create service")
+		return err
+	}
+
+	defer func() {
+		cleanupCtx, cancel := cleanupContext(ctx)
+		defer cancel()
+
+		err := k.clientset.CoreV1().Services(k.targetNamespace).Delete(cleanupCtx, svc.Name, v1.DeleteOptions{})
+		if err != nil {
+			log.WithError(err).Errorf("failed to delete stager service")
+		} else {
+			log.Infof("stager deleted service")
+		}
+	}()
+
+	stagingDebuggerUrl := fmt.Sprintf("http://%s.%s.svc.cluster.local:%d/debugger", svc.Name, k.targetNamespace, stagerPort)
+	log.Infof("The staged debugger is available at: %s", stagingDebuggerUrl)
+
+	// 2. Copy the debugger to the pod
+	log.Infof("Uploading debugger to staging pod")
+	if stager.Builtin {
+		err = k.startBuiltinStager(ctx, localPath, pod.Name)
+	} else {
+		err = k.UploadFileTar(ctx, localPath, "debugger", pod.Name, stagerContainerName)
+	}
+	if err != nil {
+		log.WithError(err).Errorf("failed to upload debugger to stager pod")
+		return err
+	}
+
+	log.Infof("Waiting for the staging service to have a ready endpoint")
+
+	if err := k.waitForServiceEndpoint(ctx, svc.Name, stager.Timeout); err != nil {
+		return err
+	}
+
+	// 3. Curl the debugger onto the pod to debug
+	log.Infof("Retrieving the debugger from the staging pod")
+	stdErr := new(Writer)
+	exitCode, err := PodExecuteCommand(ctx, ExecCommandRequest{
+		KubeRequest: KubeRequest{
+			Clientset:  k.clientset,
+			RestConfig: k.restConfig,
+			Namespace:  k.targetNamespace,
+			Pod:        podName,
+			Container:  containerName,
+		},
+		Command: []string{
+			"curl",
+			"--fail",
+			"--retry", "10",
+			"--retry-connrefused",
+			"--retry-delay", "1",
+			"-o", remotePath,
+			stagingDebuggerUrl,
+		},
+		StdIn:  nil,
+		StdOut: stdErr,
+		StdErr: stdErr,
+	})
+	if err != nil || exitCode != 0 {
+		log.WithError(err).Errorf("failed to curl the staged debugger: exitCode: '%d', stdOut/stdErr: '%s'", exitCode, stdErr.Output)
+		return errors.Errorf("failed to curl the staged debugger, exitCode: '%d'", exitCode)
+	}
+
+	// 4. Set the debugger as executable
+	log.Infof("Setting the debugger as executable")
+	exitCodeBis, err := PodExecuteCommand(ctx, ExecCommandRequest{
+		KubeRequest: KubeRequest{
+			Clientset:  k.clientset,
+			RestConfig: k.restConfig,
+			Namespace:  k.targetNamespace,
+			Pod:        podName,
+			Container:  containerName,
+		},
+		Command: []string{
+			"chmod",
+			"+x", remotePath,
+		},
+		StdIn:  nil,
+		StdOut: stdErr,
+		StdErr: stdErr,
+	})
+	if err != nil {
+		log.WithError(err).Errorf("Failed to mark the debugger as executable: exitCode: '%d', stdOut/stdErr: '%s'", exitCodeBis, stdErr.Output)
+		return err
+	}
+
+	log.Infof("Debugger uploaded on the debugger pod")
+
+	return nil
+}
+
+// rbacDenialMessage matches the message of the authorizer denying a request,
+// e.g. 'User "jane" cannot create resource "pods" in API group "" in the
+// namespace "default"'.
+var rbacDenialMessage = regexp.MustCompile(`cannot (\S+) resource "([^"]+)"`)
+
+// rbacDenial tells whether a request was forbidden by the authorizer, and not
+// by an admission controller or webhook, and returns the missing permission,
+// e.g. 'create pods'.
+func rbacDenial(err error) (string, bool) {
+	var status apierrors.APIStatus
+	if !errors.As(err, &status) || status.Status().Reason != v1.StatusReasonForbidden {
+		return "", false
+	}
+
+	message := status.Status().Message
+	if strings.Contains(message, "admission webhook") {
+		return "", false
+	}
+
+	match := rbacDenialMessage.FindStringSubmatch(message)
+	if match == nil {
+		return "", false
+	}
+
+	return match[1] + " " + match[2], true
+}
+
+// startBuiltinStager uploads the plugin binary and the debugger to the stager
+// pod, then runs the plugin binary serving the debugger over HTTP for as long
+// as the stager pod exists. Only a shell with cat, dd or base64 is required.
+func (k *KubernetesApiServiceImpl) startBuiltinStager(ctx context.Context, localPath string, stagerPodName string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Uploading the builtin stager to staging pod")
+	if err := k.UploadChunked(ctx, executable, builtinStagerPath, stagerPodName, stagerContainerName); err != nil {
+		return errors.Wrap(err, "failed to upload the builtin stager")
+	}
+
+	if err := k.UploadChunked(ctx, localPath, stagerDirectory+"/debugger", stagerPodName, stagerContainerName); err != nil {
+		return err
+	}
+
+	go func() {
+		command := []string{builtinStagerPath, BuiltinStagerCommand, "--directory", stagerDirectory, "--port", fmt.Sprint(stagerPort)}
+
+		// returns when the stager pod is deleted, or the upload interrupted
+		exitCode, err := k.ExecuteCommand(ctx, stagerPodName, stagerContainerName, command, new(NopWriter))
+		log.WithError(err).Debugf("builtin stager exited, exitCode: '%d'", exitCode)
+	}()
+
+	return nil
+}
+
+func stagerPod(namespace string, stager StagerConfig) *corev1.Pod {
+	container := corev1.Container{
+		Name:  stagerContainerName,
+		Image: stager.Image,
+		Command: []string{
+			"python3",
+			"-m",
+			"http.server",
+		},
+		WorkingDir: stagerDirectory,
+		Ports: []corev1.ContainerPort{
+			{
+				Protocol:      "TCP",
+				ContainerPort: stagerPort,
+			},
+		},
+		// ready once the debugger is served, for the service to route to it
+		ReadinessProbe: &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(stagerPort)},
+			},
+			PeriodSeconds: 1,
+		},
+		Resources: stager.Resources,
+		SecurityContext: &corev1.SecurityContext{
+			AllowPrivilegeEscalation: pointer.Bool(false),
+			Capabilities: &corev1.Capabilities{
+				Drop: []corev1.Capability{
+					"All",
+				},
+			},
+			RunAsNonRoot: pointer.Bool(true),
+			RunAsUser:    stager.RunAsUser,
+			SeccompProfile: &corev1.SeccompProfile{
+				Type: "RuntimeDefault",
+			},
+		},
+	}
+
+	if stager.Builtin {
+		// the builtin stager is uploaded once the pod runs
+		container.Command = []string{"sleep", "86400"}
+	}
+
+	var pullSecrets []corev1.LocalObjectReference
+	for _, secret := range stager.ImagePullSecrets {
+		pullSecrets = append(pullSecrets, corev1.LocalObjectReference{Name: secret})
+	}
+
+	return &corev1.Pod{
+		ObjectMeta: stagerObjectMeta(namespace, stager),
+		Spec: corev1.PodSpec{
+			Containers:       []corev1.Container{container},
+			ImagePullSecrets: pullSecrets,
+			NodeSelector:     stager.NodeSelector,
+			Tolerations:      stager.Tolerations,
+		},
+	}
+}
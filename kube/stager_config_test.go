@@ -0,0 +1,113 @@
+package kube
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestParseToleration(t *testing.T) {
+	tests := []struct {
+		value      string
+		toleration corev1.Toleration
+		valid      bool
+	}{
+		{value: "dedicated=debug:NoSchedule", valid: true,
+			toleration: corev1.Toleration{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "debug", Effect: corev1.TaintEffectNoSchedule}},
+		{value: "dedicated:NoExecute", valid: true,
+			toleration: corev1.Toleration{Key: "dedicated", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute}},
+		{value: "dedicated=:PreferNoSchedule", valid: true,
+			toleration: corev1.Toleration{Key: "dedicated", Operator: corev1.TolerationOpEqual, Effect: corev1.TaintEffectPreferNoSchedule}},
+		{value: "dedicated:Exists", valid: true,
+			toleration: corev1.Toleration{Key: "dedicated", Operator: corev1.TolerationOpExists}},
+		{value: "dedicated:", valid: true,
+			toleration: corev1.Toleration{Key: "dedicated", Operator: corev1.TolerationOpExists}},
+		{value: "dedicated", valid: false},
+		{value: ":NoSchedule", valid: false},
+		{value: "dedicated=debug:Sometimes", valid: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			toleration, err := parseToleration(test.value)
+			if (err == nil) != test.valid {
+				t.Fatalf("parseToleration(%q) error = %v, want valid: %t", test.value, err, test.valid)
+			}
+
+			if toleration != test.toleration {
+				t.Errorf("parseToleration(%q) = %+v, want %+v", test.value, toleration, test.toleration)
+			}
+		})
+	}
+}
+
+func TestParseStagerConfig(t *testing.T) {
+	tests := []struct {
+		name          string
+		image         string
+		nodeSelectors []string
+		tolerations   []string
+		cpu           string
+		memory        string
+		runAsUser     int64
+		timeout       time.Duration
+		builtin       bool
+		valid         bool
+		check         func(t *testing.T, config StagerConfig)
+	}{
+		{name: "defaults", valid: true, check: func(t *testing.T, config StagerConfig) {
+			if config.Image != PythonStagerImage || config.Timeout != DefaultStagerTimeout ||
+				*config.RunAsUser != DefaultStagerUser || config.NodeSelector != nil || len(config.Resources.Limits) != 0 {
+				t.Errorf("ParseStagerConfig() = %+v, want the defaults", config)
+			}
+		}},
+		{name: "builtin", builtin: true, valid: true, check: func(t *testing.T, config StagerConfig) {
+			if config.Image != BuiltinStagerImage || config.NodeSelector[corev1.LabelArchStable] != runtime.GOARCH {
+				t.Errorf("ParseStagerConfig() = %+v, want the builtin image on %s nodes", config, runtime.GOARCH)
+			}
+		}},
+		{name: "builtin keeps the arch selector", builtin: true, nodeSelectors: []string{corev1.LabelArchStable + "=arm64"}, valid: true,
+			check: func(t *testing.T, config StagerConfig) {
+				if config.NodeSelector[corev1.LabelArchStable] != "arm64" {
+					t.Errorf("ParseStagerConfig() node selector = %v, want arm64 nodes", config.NodeSelector)
+				}
+			}},
+		{name: "custom", image: "registry.local/python:3", nodeSelectors: []string{"pool=debug", "zone="},
+			tolerations: []string{"dedicated=debug:NoSchedule"}, cpu: "100m", memory: "64Mi", runAsUser: 1000, timeout: time.Minute, valid: true,
+			check: func(t *testing.T, config StagerConfig) {
+				if config.Image != "registry.local/python:3" || config.Timeout != time.Minute || *config.RunAsUser != 1000 {
+					t.Errorf("ParseStagerConfig() = %+v, want the specified image, timeout and user", config)
+				}
+				if len(config.NodeSelector) != 2 || config.NodeSelector["pool"] != "debug" || config.NodeSelector["zone"] != "" {
+					t.Errorf("ParseStagerConfig() node selector = %v, want pool=debug and zone=", config.NodeSelector)
+				}
+				if len(config.Tolerations) != 1 || config.Tolerations[0].Key != "dedicated" {
+					t.Errorf("ParseStagerConfig() tolerations = %+v, want dedicated", config.Tolerations)
+				}
+				if config.Resources.Limits.Cpu().String() != "100m" || config.Resources.Requests.Memory().String() != "64Mi" {
+					t.Errorf("ParseStagerConfig() resources = %+v, want 100m and 64Mi", config.Resources)
+				}
+			}},
+		{name: "invalid node selector", nodeSelectors: []string{"pool"}, valid: false},
+		{name: "empty node selector key", nodeSelectors: []string{"=debug"}, valid: false},
+		{name: "invalid toleration", tolerations: []string{"dedicated"}, valid: false},
+		{name: "invalid cpu", cpu: "a lot", valid: false},
+		{name: "invalid memory", memory: "64Mo", valid: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := ParseStagerConfig(test.image, nil, test.nodeSelectors, test.tolerations, test.cpu, test.memory,
+				test.runAsUser, test.timeout, test.builtin)
+			if (err == nil) != test.valid {
+				t.Fatalf("ParseStagerConfig() error = %v, want valid: %t", err, test.valid)
+			}
+
+			if test.check != nil {
+				test.check(t, config)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package kube
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// StagerSelector selects the resources created for the stager upload method:
+// stager pods, their services and network policies.
+const StagerSelector = "app=dmm-stager"
+
+// SessionLabel identifies the stager resources of a session, so that the
+// service and network policy of a session don't select the stager of another
+// one.
+const SessionLabel = "dmm-session"
+
+// OwnerLabel records the kubeconfig user the stager resources were created by.
+const OwnerLabel = "dmm-owner"
+
+// ExpiryAnnotation records when the stager resources can be garbage collected,
+// in RFC 3339 format.
+const ExpiryAnnotation = "debug-me-maybe/expires-at"
+
+// StagerLifetime is how long the stager resources may exist, longer than any
+// upload. They are deleted once the upload is done, unless the plugin was
+// killed in the middle of it.
+const StagerLifetime = time.Hour
+
+func stagerLabels(stager StagerConfig) map[string]string {
+	labels := map[string]string{
+		"app":        "dmm-stager",
+		SessionLabel: stager.Session,
+	}
+
+	if stager.Owner != "" {
+		labels[OwnerLabel] = stager.Owner
+	}
+
+	return labels
+}
+
+func stagerObjectMeta(namespace string, stager StagerConfig) v1.ObjectMeta {
+	return v1.ObjectMeta{
+		GenerateName: "dmm-stager-",
+		Namespace:    namespace,
+		Labels:       stagerLabels(stager),
+		Annotations: map[string]string{
+			ExpiryAnnotation: time.Now().Add(StagerLifetime).UTC().Format(time.RFC3339),
+		},
+	}
+}
+
+// StagerResource is a stager pod, service or network policy.
+type StagerResource struct {
+	Kind string
+	Meta v1.ObjectMeta
+}
+
+// ListStagerResources returns the stager pods, services and network policies
+// of the namespace of the service, or of all namespaces when it is empty.
+func (k *KubernetesApiServiceImpl) ListStagerResources(ctx context.Context) ([]StagerResource, error) {
+	options := v1.ListOptions{LabelSelector: StagerSelector}
+
+	var resources []StagerResource
+
+	pods, err := k.clientset.CoreV1().Pods(k.targetNamespace).List(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range pods.Items {
+		resources = append(resources, StagerResource{Kind: "pod", Meta: pod.ObjectMeta})
+	}
+
+	services, err := k.clientset.CoreV1().Services(k.targetNamespace).List(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	for _, service := range services.Items {
+		resources = append(resources, StagerResource{Kind: "service", Meta: service.ObjectMeta})
+	}
+
+	policies, err := k.clientset.NetworkingV1().NetworkPolicies(k.targetNamespace).List(ctx, options)
+	if err != nil {
+		log.WithError(err).Warn("failed to list the stager network policies, skipping them")
+	} else {
+		for _, policy := range policies.Items {
+			resources = append(resources, StagerResource{Kind: "networkpolicy", Meta: policy.ObjectMeta})
+		}
+	}
+
+	return resources, nil
+}
+
+// DeleteStagerResource deletes a stager resource, of any namespace.
+func (k *KubernetesApiServiceImpl) DeleteStagerResource(ctx context.Context, kind string, namespace string, name string) error {
+	switch kind {
+	case "pod":
+		return k.clientset.CoreV1().Pods(namespace).Delete(ctx, name, v1.DeleteOptions{})
+	case "service":
+		return k.clientset.CoreV1().Services(namespace).Delete(ctx, name, v1.DeleteOptions{})
+	case "networkpolicy":
+		return k.clientset.NetworkingV1().NetworkPolicies(namespace).Delete(ctx, name, v1.DeleteOptions{})
+	default:
+		return errors.Errorf("invalid stager resource kind: '%s'", kind)
+	}
+}
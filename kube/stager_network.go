@@ -0,0 +1,206 @@
+package kube
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// stagerNetworkPolicy allows only the pod to debug to reach the stager pod of
+// a session, on the stager port. The pod is selected by its labels, which its
+// replicas share, as whether IP blocks match pods depends on the network
+// plugin. Its IPs are allowed too, for the pods without labels.
+func stagerNetworkPolicy(namespace string, stager StagerConfig, target *corev1.Pod) (*networkingv1.NetworkPolicy, error) {
+	port := intstr.FromInt(stagerPort)
+	protocol := corev1.ProtocolTCP
+
+	var peers []networkingv1.NetworkPolicyPeer
+	if len(target.Labels) > 0 {
+		peers = append(peers, networkingv1.NetworkPolicyPeer{PodSelector: &v1.LabelSelector{MatchLabels: target.Labels}})
+	} else {
+		log.Warnf("pod: '%s' has no labels, the stager network policy only allows its IPs, which some network plugins don't match with pods", target.Name)
+	}
+
+	ips := target.Status.PodIPs
+	if len(ips) == 0 && target.Status.PodIP != "" {
+		ips = []corev1.PodIP{{IP: target.Status.PodIP}}
+	}
+
+	for _, podIP := range ips {
+		ip := net.ParseIP(podIP.IP)
+		if ip == nil {
+			return nil, errors.Errorf("invalid IP: '%s' of pod: '%s'", podIP.IP, target.Name)
+		}
+
+		prefix := "/128"
+		if ip.To4() != nil {
+			prefix = "/32"
+		}
+
+		peers = append(peers, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: ip.String() + prefix}})
+	}
+
+	if len(peers) == 0 {
+		return nil, errors.Errorf("pod: '%s' has neither labels nor IP to allow in the stager network policy", target.Name)
+	}
+
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: stagerObjectMeta(namespace, stager),
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: v1.LabelSelector{MatchLabels: map[string]string{SessionLabel: stager.Session}},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					From:  peers,
+					Ports: []networkingv1.NetworkPolicyPort{{Protocol: &protocol, Port: &port}},
+				},
+			},
+		},
+	}, nil
+}
+
+// blockingNetworkPolicies returns the network policies of the namespace that
+// isolate the stager pod from the pod to debug: ingress policies selecting
+// the stager without allowing the pod to debug, and egress policies selecting
+// the pod to debug without allowing the stager. Policies are allow-lists, so
+// the traffic is only blocked when none of the isolating policies allows it.
+func blockingNetworkPolicies(policies []networkingv1.NetworkPolicy, namespace string, stager map[string]string, target *corev1.Pod) (ingress []string, egress []string) {
+	var ingressAllowed, egressAllowed bool
+
+	for _, policy := range policies {
+		if selects(&policy.Spec.PodSelector, stager) && hasPolicyType(policy, networkingv1.PolicyTypeIngress) {
+			allowed := false
+			for _, rule := range policy.Spec.Ingress {
+				if peersAllow(rule.From, namespace, target.Labels) && portsAllow(rule.Ports) {
+					allowed = true
+				}
+			}
+
+			ingressAllowed = ingressAllowed || allowed
+			ingress = append(ingress, policy.Name)
+		}
+
+		if selects(&policy.Spec.PodSelector, target.Labels) && hasPolicyType(policy, networkingv1.PolicyTypeEgress) {
+			allowed := false
+			for _, rule := range policy.Spec.Egress {
+				if peersAllow(rule.To, namespace, stager) && portsAllow(rule.Ports) {
+					allowed = true
+				}
+			}
+
+			egressAllowed = egressAllowed || allowed
+			egress = append(egress, policy.Name)
+		}
+	}
+
+	if ingressAllowed {
+		ingress = nil
+	}
+
+	if egressAllowed {
+		egress = nil
+	}
+
+	return ingress, egress
+}
+
+// hasPolicyType tells whether a policy isolates pods for a type of traffic:
+// without policy types, a policy isolates ingress, and egress when it has
+// egress rules.
+func hasPolicyType(policy networkingv1.NetworkPolicy, policyType networkingv1.PolicyType) bool {
+	if len(policy.Spec.PolicyTypes) == 0 {
+		return policyType == networkingv1.PolicyTypeIngress || len(policy.Spec.Egress) > 0
+	}
+
+	for _, t := range policy.Spec.PolicyTypes {
+		if t == policyType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// peersAllow tells whether the peers of a rule include a pod of the namespace
+// with the given labels. IP blocks are assumed not to match pods.
+func peersAllow(peers []networkingv1.NetworkPolicyPeer, namespace string, podLabels map[string]string) bool {
+	if len(peers) == 0 {
+		return true
+	}
+
+	namespaceLabels := map[string]string{corev1.LabelMetadataName: namespace}
+
+	for _, peer := range peers {
+		if peer.PodSelector == nil && peer.NamespaceSelector == nil {
+			continue
+		}
+
+		if peer.NamespaceSelector != nil && !selects(peer.NamespaceSelector, namespaceLabels) {
+			continue
+		}
+
+		if peer.PodSelector == nil || selects(peer.PodSelector, podLabels) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func portsAllow(ports []networkingv1.NetworkPolicyPort) bool {
+	if len(ports) == 0 {
+		return true
+	}
+
+	for _, port := range ports {
+		if port.Protocol != nil && *port.Protocol != corev1.ProtocolTCP {
+			continue
+		}
+
+		if port.Port == nil || (port.Port.Type == intstr.Int && port.Port.IntVal == stagerPort) ||
+			(port.Port.Type == intstr.Int && port.EndPort != nil && port.Port.IntVal <= stagerPort && stagerPort <= *port.EndPort) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func selects(selector *v1.LabelSelector, podLabels map[string]string) bool {
+	s, err := v1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return false
+	}
+
+	return s.Matches(labels.Set(podLabels))
+}
+
+// checkNetworkPolicies warns about the network policies that would keep the
+// pod to debug from retrieving the debugger from the stager.
+func (k *KubernetesApiServiceImpl) checkNetworkPolicies(ctx context.Context, target *corev1.Pod, stager StagerConfig) {
+	policies, err := k.clientset.NetworkingV1().NetworkPolicies(k.targetNamespace).List(ctx, v1.ListOptions{})
+	if err != nil {
+		log.WithError(err).Debugf("failed to list the network policies of namespace: '%s'", k.targetNamespace)
+		return
+	}
+
+	ingress, egress := blockingNetworkPolicies(policies.Items, k.targetNamespace, stagerLabels(stager), target)
+
+	if len(ingress) > 0 && !stager.NetworkPolicy {
+		log.Warnf("network policies: '%s' block the ingress of the stager pod, use '--stager-network-policy' to allow pod: '%s' to reach it",
+			strings.Join(ingress, "', '"), target.Name)
+	}
+
+	if len(egress) > 0 {
+		log.Warnf("network policies: '%s' block the egress of pod: '%s' to the stager pod, retrieving the debugger will likely fail, "+
+			"allow it to reach pods labeled 'app=dmm-stager' on port %d", strings.Join(egress, "', '"), target.Name, stagerPort)
+	}
+}
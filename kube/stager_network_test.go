@@ -0,0 +1,212 @@
+package kube
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestPeersAllow(t *testing.T) {
+	podLabels := map[string]string{"app": "operator"}
+
+	tests := []struct {
+		name    string
+		peers   []networkingv1.NetworkPolicyPeer
+		allowed bool
+	}{
+		{name: "no peers", allowed: true},
+		{name: "matching pod selector", allowed: true,
+			peers: []networkingv1.NetworkPolicyPeer{{PodSelector: &v1.LabelSelector{MatchLabels: podLabels}}}},
+		{name: "empty pod selector", allowed: true,
+			peers: []networkingv1.NetworkPolicyPeer{{PodSelector: &v1.LabelSelector{}}}},
+		{name: "other pod selector", allowed: false,
+			peers: []networkingv1.NetworkPolicyPeer{{PodSelector: &v1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}}}},
+		{name: "matching namespace selector", allowed: true,
+			peers: []networkingv1.NetworkPolicyPeer{{NamespaceSelector: &v1.LabelSelector{
+				MatchLabels: map[string]string{corev1.LabelMetadataName: "system"}}}}},
+		{name: "other namespace selector", allowed: false,
+			peers: []networkingv1.NetworkPolicyPeer{{NamespaceSelector: &v1.LabelSelector{
+				MatchLabels: map[string]string{corev1.LabelMetadataName: "other"}}, PodSelector: &v1.LabelSelector{}}}},
+		{name: "ip block only", allowed: false,
+			peers: []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/8"}}}},
+		{name: "any matching peer", allowed: true,
+			peers: []networkingv1.NetworkPolicyPeer{
+				{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/8"}},
+				{PodSelector: &v1.LabelSelector{MatchLabels: podLabels}},
+			}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if allowed := peersAllow(test.peers, "system", podLabels); allowed != test.allowed {
+				t.Errorf("peersAllow() = %t, want %t", allowed, test.allowed)
+			}
+		})
+	}
+}
+
+func TestPortsAllow(t *testing.T) {
+	tcp, udp := corev1.ProtocolTCP, corev1.ProtocolUDP
+	stager, other := intstr.FromInt(stagerPort), intstr.FromInt(8080)
+	named := intstr.FromString("http")
+	endPort, belowEndPort := int32(stagerPort+10), int32(stagerPort-1)
+	start := intstr.FromInt(stagerPort - 5)
+
+	tests := []struct {
+		name    string
+		ports   []networkingv1.NetworkPolicyPort
+		allowed bool
+	}{
+		{name: "no ports", allowed: true},
+		{name: "stager port", ports: []networkingv1.NetworkPolicyPort{{Port: &stager}}, allowed: true},
+		{name: "stager port over tcp", ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &stager}}, allowed: true},
+		{name: "stager port over udp", ports: []networkingv1.NetworkPolicyPort{{Protocol: &udp, Port: &stager}}, allowed: false},
+		{name: "any tcp port", ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp}}, allowed: true},
+		{name: "other port", ports: []networkingv1.NetworkPolicyPort{{Port: &other}}, allowed: false},
+		{name: "named port", ports: []networkingv1.NetworkPolicyPort{{Port: &named}}, allowed: false},
+		{name: "port range", ports: []networkingv1.NetworkPolicyPort{{Port: &start, EndPort: &endPort}}, allowed: true},
+		{name: "port range below", ports: []networkingv1.NetworkPolicyPort{{Port: &start, EndPort: &belowEndPort}}, allowed: false},
+		{name: "any matching port", ports: []networkingv1.NetworkPolicyPort{{Port: &other}, {Port: &stager}}, allowed: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if allowed := portsAllow(test.ports); allowed != test.allowed {
+				t.Errorf("portsAllow() = %t, want %t", allowed, test.allowed)
+			}
+		})
+	}
+}
+
+func TestBlockingNetworkPolicies(t *testing.T) {
+	stager := map[string]string{"app": "dmm-stager", SessionLabel: "abc"}
+	target := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "operator-0", Labels: map[string]string{"app": "operator"}}}
+
+	denyIngress := networkingv1.NetworkPolicy{
+		ObjectMeta: v1.ObjectMeta{Name: "deny-ingress"},
+		Spec: networkingv1.NetworkPolicySpec{
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+		},
+	}
+	denyEgress := networkingv1.NetworkPolicy{
+		ObjectMeta: v1.ObjectMeta{Name: "deny-egress"},
+		Spec: networkingv1.NetworkPolicySpec{
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+		},
+	}
+	allowIngress := networkingv1.NetworkPolicy{
+		ObjectMeta: v1.ObjectMeta{Name: "allow-operator"},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: v1.LabelSelector{MatchLabels: map[string]string{"app": "dmm-stager"}},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{From: []networkingv1.NetworkPolicyPeer{{PodSelector: &v1.LabelSelector{MatchLabels: map[string]string{"app": "operator"}}}}},
+			},
+		},
+	}
+	allowEgress := networkingv1.NetworkPolicy{
+		ObjectMeta: v1.ObjectMeta{Name: "allow-stager"},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: v1.LabelSelector{MatchLabels: map[string]string{"app": "operator"}},
+			Egress: []networkingv1.NetworkPolicyEgressRule{
+				{To: []networkingv1.NetworkPolicyPeer{{PodSelector: &v1.LabelSelector{MatchLabels: map[string]string{"app": "dmm-stager"}}}}},
+			},
+		},
+	}
+	otherPods := networkingv1.NetworkPolicy{
+		ObjectMeta: v1.ObjectMeta{Name: "web"},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: v1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		policies []networkingv1.NetworkPolicy
+		ingress  []string
+		egress   []string
+	}{
+		{name: "no policies"},
+		{name: "default deny", policies: []networkingv1.NetworkPolicy{denyIngress, denyEgress},
+			ingress: []string{"deny-ingress"}, egress: []string{"deny-egress"}},
+		{name: "default deny with allows", policies: []networkingv1.NetworkPolicy{denyIngress, denyEgress, allowIngress, allowEgress}},
+		{name: "ingress allowed only", policies: []networkingv1.NetworkPolicy{denyIngress, denyEgress, allowIngress},
+			egress: []string{"deny-egress"}},
+		{name: "policies of other pods", policies: []networkingv1.NetworkPolicy{otherPods}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ingress, egress := blockingNetworkPolicies(test.policies, "system", stager, target)
+			if !reflect.DeepEqual(ingress, test.ingress) || !reflect.DeepEqual(egress, test.egress) {
+				t.Errorf("blockingNetworkPolicies() = %v, %v, want %v, %v", ingress, egress, test.ingress, test.egress)
+			}
+		})
+	}
+}
+
+func TestStagerNetworkPolicy(t *testing.T) {
+	labels := map[string]string{"app": "operator"}
+
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		status   corev1.PodStatus
+		selected bool
+		cidrs    []string
+		valid    bool
+	}{
+		{name: "labels and ipv4", labels: labels, status: corev1.PodStatus{PodIP: "10.1.2.3", PodIPs: []corev1.PodIP{{IP: "10.1.2.3"}}},
+			selected: true, cidrs: []string{"10.1.2.3/32"}, valid: true},
+		{name: "dual stack", labels: labels, status: corev1.PodStatus{PodIP: "10.1.2.3", PodIPs: []corev1.PodIP{{IP: "10.1.2.3"}, {IP: "fd00::3"}}},
+			selected: true, cidrs: []string{"10.1.2.3/32", "fd00::3/128"}, valid: true},
+		{name: "pod ip only", labels: labels, status: corev1.PodStatus{PodIP: "fd00::3"}, selected: true, cidrs: []string{"fd00::3/128"}, valid: true},
+		{name: "labels only", labels: labels, selected: true, valid: true},
+		{name: "no labels", status: corev1.PodStatus{PodIP: "10.1.2.3"}, cidrs: []string{"10.1.2.3/32"}, valid: true},
+		{name: "neither labels nor ip", valid: false},
+		{name: "invalid ip", labels: labels, status: corev1.PodStatus{PodIP: "10.1.2"}, valid: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "operator-0", Labels: test.labels}, Status: test.status}
+
+			policy, err := stagerNetworkPolicy("system", StagerConfig{Session: "abc"}, target)
+			if (err == nil) != test.valid {
+				t.Fatalf("stagerNetworkPolicy() error = %v, want valid: %t", err, test.valid)
+			}
+			if err != nil {
+				return
+			}
+
+			var selected bool
+			var cidrs []string
+			for _, peer := range policy.Spec.Ingress[0].From {
+				switch {
+				case peer.PodSelector != nil && peer.NamespaceSelector == nil && peer.IPBlock == nil:
+					selected = reflect.DeepEqual(peer.PodSelector.MatchLabels, test.labels)
+				case peer.IPBlock != nil && peer.PodSelector == nil && peer.NamespaceSelector == nil:
+					cidrs = append(cidrs, peer.IPBlock.CIDR)
+				default:
+					t.Errorf("stagerNetworkPolicy() peer = %+v, want a pod selector or an IP block", peer)
+				}
+			}
+
+			if selected != test.selected || !reflect.DeepEqual(cidrs, test.cidrs) {
+				t.Errorf("stagerNetworkPolicy() allows the pod labels: %t and %v, want %t and %v", selected, cidrs, test.selected, test.cidrs)
+			}
+
+			if !peersAllow(policy.Spec.Ingress[0].From, "system", test.labels) && len(test.labels) > 0 {
+				t.Errorf("stagerNetworkPolicy() doesn't allow the pods labeled %v", test.labels)
+			}
+
+			if policy.Spec.PodSelector.MatchLabels[SessionLabel] != "abc" {
+				t.Errorf("stagerNetworkPolicy() selects %v, want the stager of session abc", policy.Spec.PodSelector.MatchLabels)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package kube
+
+import (
+	"errors"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestRbacDenial(t *testing.T) {
+	pods := schema.GroupResource{Resource: "pods"}
+
+	tests := []struct {
+		name       string
+		err        error
+		permission string
+		denied     bool
+	}{
+		{name: "rbac", denied: true, permission: "create pods",
+			err: apierrors.NewForbidden(pods, "", errors.New(`User "jane" cannot create resource "pods" in API group "" in the namespace "system"`))},
+		{name: "admission webhook", denied: false,
+			err: apierrors.NewForbidden(pods, "dmm-stager-x", errors.New(`admission webhook "policy.example.com" denied the request: cannot create resource "pods"`))},
+		{name: "pod security", denied: false,
+			err: apierrors.NewForbidden(pods, "dmm-stager-x", errors.New(`violates PodSecurity "restricted:latest": runAsNonRoot != true`))},
+		{name: "invalid", denied: false,
+			err: apierrors.NewInvalid(schema.GroupKind{Kind: "Pod"}, "dmm-stager-x", nil)},
+		{name: "other error", denied: false, err: errors.New("connection refused")},
+		{name: "no error", denied: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			permission, denied := rbacDenial(test.err)
+			if permission != test.permission || denied != test.denied {
+				t.Errorf("rbacDenial() = %q, %t, want %q, %t", permission, denied, test.permission, test.denied)
+			}
+		})
+	}
+}
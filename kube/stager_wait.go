@@ -0,0 +1,226 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+const DefaultStagerTimeout = 5 * time.Minute
+
+// fatalWaitingReasons are the reasons of a waiting container that won't start
+// without a change of the pod. The kubelet retries the others, e.g. a first
+// ErrImagePull or a CreateContainerError, until the stager timeout.
+var fatalWaitingReasons = map[string]bool{
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+}
+
+// waitForStagerPod watches the stager pod until its container runs, failing
+// early when it can't start and explaining why it didn't start in time.
+func (k *KubernetesApiServiceImpl) waitForStagerPod(ctx context.Context, podName string, timeout time.Duration) error {
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	podsClient := k.clientset.CoreV1().Pods(k.targetNamespace)
+	selector := fields.OneTermEqualSelector("metadata.name", podName).String()
+
+	lw := &cache.ListWatch{
+		ListFunc: func(options v1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector
+			return podsClient.List(ctx, options)
+		},
+		WatchFunc: func(options v1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			return podsClient.Watch(ctx, options)
+		},
+	}
+
+	var pending string
+
+	_, err := watchtools.UntilWithSync(ctx, lw, &corev1.Pod{}, nil, func(event watch.Event) (bool, error) {
+		if event.Type == watch.Deleted {
+			return false, errors.Errorf("stager pod: '%s' was deleted", podName)
+		}
+
+		pod, ok := event.Object.(*corev1.Pod)
+		if !ok {
+			return false, nil
+		}
+
+		if running, err := stagerPodRunning(pod); running || err != nil {
+			return running, err
+		}
+
+		pending = describePendingPod(pod)
+		log.Debugf("stager pod: '%s' not running yet: %s", podName, pending)
+
+		return false, nil
+	})
+
+	if err == nil {
+		log.Infof("Stager pod is running")
+		return nil
+	}
+
+	if parent.Err() != nil {
+		return parent.Err()
+	}
+
+	if !errors.Is(err, watchtools.ErrWatchClosed) && ctx.Err() == nil {
+		return err
+	}
+
+	message := fmt.Sprintf("stager pod: '%s' isn't running after %s", podName, timeout)
+	if pending != "" {
+		message += ": " + pending
+	}
+
+	if events := k.warningEvents(parent, podName); events != "" {
+		message += "; events: " + events
+	}
+
+	return errors.New(message + ", see '--stager-timeout'")
+}
+
+// stagerPodRunning tells whether the container of the stager pod runs, failing
+// when the pod exited or can't start.
+func stagerPodRunning(pod *corev1.Pod) (bool, error) {
+	if pod.Status.Phase == corev1.PodFailed || pod.Status.Phase == corev1.PodSucceeded {
+		return false, errors.Errorf("stager pod: '%s' exited (phase: '%s', reason: '%s')", pod.Name, pod.Status.Phase, pod.Status.Reason)
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Running != nil {
+			return true, nil
+		}
+
+		if waiting := status.State.Waiting; waiting != nil && fatalWaitingReasons[waiting.Reason] {
+			return false, errors.Errorf("stager pod: '%s' can't start: %s: %s", pod.Name, waiting.Reason, waiting.Message)
+		}
+	}
+
+	return false, nil
+}
+
+// describePendingPod explains why a pod isn't running yet from its conditions
+// and container statuses.
+func describePendingPod(pod *corev1.Pod) string {
+	var reasons []string
+	for _, condition := range pod.Status.Conditions {
+		if condition.Status == corev1.ConditionFalse && condition.Reason != "" {
+			reasons = append(reasons, fmt.Sprintf("%s: %s (%s)", condition.Type, condition.Reason, condition.Message))
+		}
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if waiting := status.State.Waiting; waiting != nil && waiting.Reason != "" {
+			reasons = append(reasons, fmt.Sprintf("container %s: %s %s", status.Name, waiting.Reason, waiting.Message))
+		}
+	}
+
+	if len(reasons) == 0 {
+		return fmt.Sprintf("phase: '%s'", pod.Status.Phase)
+	}
+
+	return strings.Join(reasons, ", ")
+}
+
+// warningEvents returns the latest warning events of a pod of the namespace,
+// e.g. FailedScheduling or a denied admission, or an empty string.
+func (k *KubernetesApiServiceImpl) warningEvents(ctx context.Context, podName string) string {
+	events, err := k.clientset.CoreV1().Events(k.targetNamespace).List(ctx, v1.ListOptions{
+		FieldSelector: fields.AndSelectors(
+			fields.OneTermEqualSelector("involvedObject.name", podName),
+			fields.OneTermEqualSelector("type", corev1.EventTypeWarning),
+		).String(),
+	})
+	if err != nil {
+		log.WithError(err).Debugf("failed to list the events of pod: '%s'", podName)
+		return ""
+	}
+
+	items := events.Items
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].LastTimestamp.Before(&items[j].LastTimestamp)
+	})
+
+	if len(items) > 3 {
+		items = items[len(items)-3:]
+	}
+
+	var messages []string
+	for _, event := range items {
+		messages = append(messages, fmt.Sprintf("%s: %s", event.Reason, event.Message))
+	}
+
+	return strings.Join(messages, ", ")
+}
+
+// waitForServiceEndpoint watches the EndpointSlices of a service until one of
+// them has a ready address, so that the stager is reachable through it.
+func (k *KubernetesApiServiceImpl) waitForServiceEndpoint(ctx context.Context, serviceName string, timeout time.Duration) error {
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	slicesClient := k.clientset.DiscoveryV1().EndpointSlices(k.targetNamespace)
+	selector := discoveryv1.LabelServiceName + "=" + serviceName
+
+	if _, err := slicesClient.List(ctx, v1.ListOptions{LabelSelector: selector, Limit: 1}); err != nil {
+		log.WithError(err).Warnf("failed to list the endpoints of service: '%s', not waiting for them", serviceName)
+		return nil
+	}
+
+	lw := &cache.ListWatch{
+		ListFunc: func(options v1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = selector
+			return slicesClient.List(ctx, options)
+		},
+		WatchFunc: func(options v1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = selector
+			return slicesClient.Watch(ctx, options)
+		},
+	}
+
+	_, err := watchtools.UntilWithSync(ctx, lw, &discoveryv1.EndpointSlice{}, nil, func(event watch.Event) (bool, error) {
+		slice, ok := event.Object.(*discoveryv1.EndpointSlice)
+		if !ok || event.Type == watch.Deleted {
+			return false, nil
+		}
+
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.Conditions.Ready != nil && *endpoint.Conditions.Ready && len(endpoint.Addresses) > 0 {
+				log.Infof("service: '%s' has a ready endpoint: '%s'", serviceName, endpoint.Addresses[0])
+				return true, nil
+			}
+		}
+
+		return false, nil
+	})
+
+	if parent.Err() != nil {
+		return parent.Err()
+	}
+
+	if err != nil && ctx.Err() != nil {
+		return errors.Errorf("service: '%s' has no ready endpoint after %s, see '--stager-timeout'", serviceName, timeout)
+	}
+
+	return err
+}
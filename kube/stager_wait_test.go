@@ -0,0 +1,47 @@
+package kube
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestStagerPodRunning(t *testing.T) {
+	waiting := func(reason string) corev1.PodStatus {
+		return corev1.PodStatus{Phase: corev1.PodPending, ContainerStatuses: []corev1.ContainerStatus{
+			{Name: "stager", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason}}},
+		}}
+	}
+
+	tests := []struct {
+		name    string
+		status  corev1.PodStatus
+		running bool
+		fatal   bool
+	}{
+		{name: "scheduling", status: corev1.PodStatus{Phase: corev1.PodPending}},
+		{name: "creating", status: waiting("ContainerCreating")},
+		{name: "image pull error", status: waiting("ErrImagePull")},
+		{name: "create container error", status: waiting("CreateContainerError")},
+		{name: "image pull backoff", status: waiting("ImagePullBackOff"), fatal: true},
+		{name: "invalid image name", status: waiting("InvalidImageName"), fatal: true},
+		{name: "create container config error", status: waiting("CreateContainerConfigError"), fatal: true},
+		{name: "failed", status: corev1.PodStatus{Phase: corev1.PodFailed}, fatal: true},
+		{name: "succeeded", status: corev1.PodStatus{Phase: corev1.PodSucceeded}, fatal: true},
+		{name: "running", running: true, status: corev1.PodStatus{Phase: corev1.PodRunning, ContainerStatuses: []corev1.ContainerStatus{
+			{Name: "stager", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+		}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "dmm-stager-x"}, Status: test.status}
+
+			running, err := stagerPodRunning(pod)
+			if running != test.running || (err != nil) != test.fatal {
+				t.Errorf("stagerPodRunning() = %t, %v, want %t, fatal: %t", running, err, test.running, test.fatal)
+			}
+		})
+	}
+}
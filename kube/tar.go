@@ -3,27 +3,62 @@ package kube
 import (
 	"archive/tar"
 	"bytes"
+	"compress/gzip"
+	"io"
 )
 
-func WrapAsTar(fileNameOnTar string, fileContent []byte) ([]byte, error) {
-	var buf bytes.Buffer
-	tw := tar.NewWriter(&buf)
+// StreamAsTar returns a reader of a tar archive holding a single file, read
+// from content as the archive is read, gzipped when compress is set. Closing
+// the reader stops the goroutine writing the archive.
+func StreamAsTar(fileNameOnTar string, content io.Reader, size int64, compress bool) *io.PipeReader {
+	reader, writer := io.Pipe()
+
+	go func() {
+		writer.CloseWithError(writeTar(writer, fileNameOnTar, content, size, compress))
+	}()
+
+	return reader
+}
+
+func writeTar(out io.Writer, fileNameOnTar string, content io.Reader, size int64, compress bool) error {
+	var gw *gzip.Writer
+	if compress {
+		gw = gzip.NewWriter(out)
+		out = gw
+	}
+
+	tw := tar.NewWriter(out)
 
 	hdr := &tar.Header{
 		Name: fileNameOnTar,
 		Mode: 0755,
-		Size: int64(len(fileContent)),
+		Size: size,
 	}
 
 	if err := tw.WriteHeader(hdr); err != nil {
-		return nil, err
+		return err
 	}
 
-	if _, err := tw.Write(fileContent); err != nil {
-		return nil, err
+	if _, err := io.CopyN(tw, content, size); err != nil {
+		return err
 	}
 
 	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	if gw != nil {
+		return gw.Close()
+	}
+
+	return nil
+}
+
+// emptyGzipTar returns a gzipped tar archive of an empty file, to check
+// whether the tar of a container can extract gzipped archives.
+func emptyGzipTar() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeTar(&buf, ".dmm-probe", bytes.NewReader(nil), 0, true); err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,93 @@
+package kube
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrFileMismatch is returned when a file on a container is missing or doesn't
+// have the content of the local file it was uploaded from.
+var ErrFileMismatch = errors.New("remote file doesn't match the local file")
+
+// verifyScript prints the SHA-256 of a file ($0), or its size when sha256sum
+// isn't available on the container.
+const verifyScript = `[ -f "$0" ] || { echo missing; exit 0; }
+if command -v sha256sum >/dev/null 2>&1; then
+  echo "sha256 $(sha256sum < "$0")"
+else
+  echo "size $(wc -c < "$0")"
+fi`
+
+// FileSha256 returns the hex encoded SHA-256 of a local file.
+func FileSha256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// VerifyFile checks that a file on the container has the content of a local
+// file, by SHA-256, or only by size when sha256sum isn't available. A missing
+// or different file is reported with ErrFileMismatch.
+func (k *KubernetesApiServiceImpl) VerifyFile(ctx context.Context, localPath string, remotePath string, podName string, containerName string) error {
+	stdOut := new(Writer)
+
+	exitCode, err := k.ExecuteCommand(ctx, podName, containerName, []string{"/bin/sh", "-c", verifyScript, remotePath}, stdOut)
+	if err != nil || exitCode != 0 {
+		return errors.Errorf("failed to verify '%s' on container: '%s', exit code: '%d'", remotePath, containerName, exitCode)
+	}
+
+	fields := strings.Fields(stdOut.Output)
+	if len(fields) == 1 && fields[0] == "missing" {
+		return errors.Wrapf(ErrFileMismatch, "'%s' not found", remotePath)
+	}
+
+	if len(fields) < 2 {
+		return errors.Errorf("unexpected output verifying '%s': '%s'", remotePath, strings.TrimSpace(stdOut.Output))
+	}
+
+	switch fields[0] {
+	case "sha256":
+		expected, err := FileSha256(localPath)
+		if err != nil {
+			return err
+		}
+
+		if fields[1] != expected {
+			return errors.Wrapf(ErrFileMismatch, "SHA-256 of '%s' is '%s' instead of '%s'", remotePath, fields[1], expected)
+		}
+
+		log.Infof("SHA-256 of '%s' verified: '%s'", remotePath, expected)
+	case "size":
+		info, err := os.Stat(localPath)
+		if err != nil {
+			return err
+		}
+
+		if fields[1] != strconv.FormatInt(info.Size(), 10) {
+			return errors.Wrapf(ErrFileMismatch, "size of '%s' is '%s' bytes instead of '%d'", remotePath, fields[1], info.Size())
+		}
+
+		log.Warnf("sha256sum isn't available on container: '%s', only the size of '%s' was verified", containerName, remotePath)
+	default:
+		return errors.Errorf("unexpected output verifying '%s': '%s'", remotePath, strings.TrimSpace(stdOut.Output))
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package arch
+
+import (
+	"debug/elf"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var unameMachines = map[string]string{
+	"x86_64":  "amd64",
+	"amd64":   "amd64",
+	"aarch64": "arm64",
+	"arm64":   "arm64",
+	"armv7l":  "arm",
+	"armv6l":  "arm",
+	"i386":    "386",
+	"i686":    "386",
+	"ppc64le": "ppc64le",
+	"s390x":   "s390x",
+}
+
+var elfMachines = map[elf.Machine]string{
+	elf.EM_X86_64:  "amd64",
+	elf.EM_AARCH64: "arm64",
+	elf.EM_ARM:     "arm",
+	elf.EM_386:     "386",
+	elf.EM_PPC64:   "ppc64le",
+	elf.EM_S390:    "s390x",
+}
+
+// FromUname converts the output of 'uname -m' to a GOARCH.
+func FromUname(machine string) (string, error) {
+	goarch, ok := unameMachines[strings.TrimSpace(machine)]
+	if !ok {
+		return "", errors.Errorf("unknown machine: '%s'", strings.TrimSpace(machine))
+	}
+
+	return goarch, nil
+}
+
+// FromELF returns the GOARCH an ELF binary was built for.
+func FromELF(path string) (string, error) {
+	file, err := elf.Open(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read ELF header of '%s'", path)
+	}
+	defer file.Close()
+
+	goarch, ok := elfMachines[file.Machine]
+	if !ok {
+		return "", errors.Errorf("unsupported ELF machine: '%s' for '%s'", file.Machine, path)
+	}
+
+	return goarch, nil
+}
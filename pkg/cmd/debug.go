@@ -5,9 +5,14 @@ import (
 	"debug-me-maybe/kube"
 	"debug-me-maybe/pkg/config"
 	"debug-me-maybe/pkg/service/debugger"
+	"debug-me-maybe/pkg/service/forwarder"
+	"debug-me-maybe/pkg/service/lease"
+	"debug-me-maybe/pkg/service/preflight"
+	"debug-me-maybe/pkg/service/probe"
+	"debug-me-maybe/pkg/session"
+	"encoding/json"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
@@ -18,6 +23,7 @@ import (
 	"github.com/spf13/viper"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -30,47 +36,83 @@ import (
 )
 
 var (
-	dmmExample = "kubectl dmm pod hello-minikube-7c77b68cff-qbvsd"
+	dmmExample = `  # debug pid 1 of a pod
+  kubectl dmm hello-minikube-7c77b68cff-qbvsd -P 1
+
+  # debug the second ready pod of a deployment
+  kubectl dmm deploy/hello-minikube --pod-index 1
+
+  # debug the first pod matching a label selector, without prompting
+  kubectl dmm -l app=hello-minikube --first
+
+  # check whether a deployment can be debugged
+  kubectl dmm doctor deploy/hello-minikube
+
+  # debug a pod named like a subcommand
+  kubectl dmm pod/session -P 1
+
+  # list the debug sessions of all namespaces
+  kubectl dmm session list -A`
 )
 
 const minimumNumberOfArguments = 1
 const dlvBinaryName = "dlv"
-const dlvRemotePath = "/tmp/dlv"
+const ephemeralImage = "docker.io/library/busybox:latest"
 
+var dlvRemoteDirs = []string{"/tmp", "/var/tmp", "/dev/shm"}
 var dlvLocalBinaryPathLookupList []string
 
 type DMM struct {
-	configFlags      *genericclioptions.ConfigFlags
-	resultingContext *api.Context
-	clientset        *kubernetes.Clientset
-	restConfig       *rest.Config
-	rawConfig        api.Config
-	settings         *config.DMMSettings
-	debuggerService  debugger.DebuggerService
+	configFlags          *genericclioptions.ConfigFlags
+	resultingContext     *api.Context
+	clientset            *kubernetes.Clientset
+	restConfig           *rest.Config
+	rawConfig            api.Config
+	settings             *config.DMMSettings
+	debuggerService      debugger.DebuggerService
+	kubernetesApiService kube.KubernetesApiService
+	sessionRegistry      *session.Registry
+	probeGuardService    *probe.ProbeGuardService
+	doctorMode           bool
+	accessCheck          *preflight.Check
+	debugSession         session.Session
+	streams              genericclioptions.IOStreams
 }
 
-func NewDMM(settings *config.DMMSettings) *DMM {
-	return &DMM{settings: settings, configFlags: genericclioptions.NewConfigFlags(true)}
+func NewDMM(settings *config.DMMSettings, streams genericclioptions.IOStreams) *DMM {
+	return &DMM{settings: settings, configFlags: genericclioptions.NewConfigFlags(true), streams: streams}
 }
 
 func NewCmdSniff(streams genericclioptions.IOStreams) *cobra.Command {
 	dmmSettings := config.NewDMMSettings(streams)
 
-	dmm := NewDMM(dmmSettings)
+	dmm := NewDMM(dmmSettings, streams)
 
 	cmd := &cobra.Command{
-		Use:          "dmm pod [-n namespace] [-c container] [-P pid]",
+		Use:          "dmm (POD | TYPE/NAME | -l selector) [-n namespace] [-c container] [-P pid]",
 		Short:        "Debug Me Maybe. Attaches a dlv debugger on a running process in a pod.",
 		Example:      dmmExample,
+		Args:         cobra.ArbitraryArgs,
 		SilenceUsage: true,
 		RunE: func(c *cobra.Command, args []string) error {
 			if err := dmm.Complete(c, args); err != nil {
 				return err
 			}
-			if err := dmm.Validate(); err != nil {
+			defer func() {
+				if dmm.detached(c.Context()) {
+					return
+				}
+
+				ctx, cancel := cleanupContext()
+				defer cancel()
+
+				dmm.restoreLivenessProbe(ctx)
+			}()
+
+			if err := dmm.Validate(c.Context()); err != nil {
 				return err
 			}
-			if err := dmm.Run(); err != nil {
+			if err := dmm.Run(c.Context()); err != nil {
 				return err
 			}
 
@@ -78,97 +120,322 @@ func NewCmdSniff(streams genericclioptions.IOStreams) *cobra.Command {
 		},
 	}
 
-	cmd.Flags().StringVarP(&dmmSettings.UserSpecifiedNamespace, "namespace", "n", "", "namespace (optional)")
+	cmd.PersistentFlags().StringVarP(&dmmSettings.UserSpecifiedNamespace, "namespace", "n", "", "namespace (optional)")
 	_ = viper.BindEnv("namespace", "KUBECTL_PLUGINS_CURRENT_NAMESPACE")
-	_ = viper.BindPFlag("namespace", cmd.Flags().Lookup("namespace"))
+	_ = viper.BindPFlag("namespace", cmd.PersistentFlags().Lookup("namespace"))
 
-	cmd.Flags().IntVarP(&dmmSettings.UserSpecifiedPid, "pid", "P", 1, "PID of the process to debug (optional)")
+	cmd.Flags().IntVarP(&dmmSettings.UserSpecifiedPid, "pid", "P", 0,
+		"PID of the process to debug, the Go processes of the container are looked up when unset (optional)")
 	_ = viper.BindEnv("pid", "KUBECTL_PLUGINS_LOCAL_FLAG_PID")
 	_ = viper.BindPFlag("pid", cmd.Flags().Lookup("pid"))
 
+	cmd.Flags().StringVar(&dmmSettings.UserSpecifiedProcessName, "process-name", "",
+		"name of the process to debug, matched against its executable and argv[0] base names (optional)")
+	_ = viper.BindEnv("process-name", "KUBECTL_PLUGINS_LOCAL_FLAG_PROCESS_NAME")
+	_ = viper.BindPFlag("process-name", cmd.Flags().Lookup("process-name"))
+
+	cmd.Flags().StringVar(&dmmSettings.UserSpecifiedExePath, "exe", "",
+		"absolute path of the executable of the process to debug (optional)")
+	_ = viper.BindEnv("exe", "KUBECTL_PLUGINS_LOCAL_FLAG_EXE")
+	_ = viper.BindPFlag("exe", cmd.Flags().Lookup("exe"))
+
 	cmd.Flags().StringVarP(&dmmSettings.UserSpecifiedContainer, "container", "c", "", "container (optional)")
 	_ = viper.BindEnv("container", "KUBECTL_PLUGINS_LOCAL_FLAG_CONTAINER")
 	_ = viper.BindPFlag("container", cmd.Flags().Lookup("container"))
 
-	cmd.Flags().BoolVarP(&dmmSettings.UserSpecifiedVerboseMode, "verbose", "v", false,
+	cmd.PersistentFlags().BoolVarP(&dmmSettings.UserSpecifiedVerboseMode, "verbose", "v", false,
 		"if specified, dmm output will include debug information (optional)")
 	_ = viper.BindEnv("verbose", "KUBECTL_PLUGINS_LOCAL_FLAG_VERBOSE")
-	_ = viper.BindPFlag("verbose", cmd.Flags().Lookup("verbose"))
+	_ = viper.BindPFlag("verbose", cmd.PersistentFlags().Lookup("verbose"))
 
-	cmd.Flags().StringVarP(&dmmSettings.UserSpecifiedKubeContext, "context", "x", "",
+	cmd.PersistentFlags().StringVarP(&dmmSettings.UserSpecifiedKubeContext, "context", "x", "",
 		"kubectl context to work on (optional)")
 	_ = viper.BindEnv("context", "KUBECTL_PLUGINS_CURRENT_CONTEXT")
-	_ = viper.BindPFlag("context", cmd.Flags().Lookup("context"))
+	_ = viper.BindPFlag("context", cmd.PersistentFlags().Lookup("context"))
 
 	cmd.Flags().StringVarP(&dmmSettings.UserSpecifiedLocalDlvPath, "local-dlv-path", "f", "",
-		"local dlv binary path (optional)")
+		"local dlv binary path, or directory of per-arch dlv binaries named dlv-linux-<arch> (optional)")
 	_ = viper.BindEnv("local-dlv-path", "KUBECTL_PLUGINS_LOCAL_FLAG_LOCAL_DLV_PATH")
 	_ = viper.BindPFlag("local-dlv-path", cmd.Flags().Lookup("local-dlv-path"))
 
-	cmd.Flags().StringVarP(&dmmSettings.UserSpecifiedRemoteDlvPath, "remote-dlv-path", "r", dlvRemotePath,
-		"remote dlv binary path (optional)")
+	cmd.Flags().StringVarP(&dmmSettings.UserSpecifiedRemoteDlvPath, "remote-dlv-path", "r", "",
+		"remote dlv binary path, the remote directories are probed when unset (optional)")
 	_ = viper.BindEnv("remote-dlv-path", "KUBECTL_PLUGINS_LOCAL_FLAG_REMOTE_DLV_PATH")
 	_ = viper.BindPFlag("remote-dlv-path", cmd.Flags().Lookup("remote-dlv-path"))
 
+	cmd.Flags().StringSliceVar(&dmmSettings.UserSpecifiedRemoteDlvDirs, "remote-dlv-dir", dlvRemoteDirs,
+		"candidate remote directories for dlv, the first writable one allowing execution is used, falling back to such a mount of the container (optional)")
+	_ = viper.BindEnv("remote-dlv-dir", "KUBECTL_PLUGINS_LOCAL_FLAG_REMOTE_DLV_DIR")
+	_ = viper.BindPFlag("remote-dlv-dir", cmd.Flags().Lookup("remote-dlv-dir"))
+
 	cmd.Flags().IntVarP(&dmmSettings.UserSpecifiedDebuggerPort, "debugger-port", "d", 2345,
 		"remote dlv port to listen on (optional)")
 	_ = viper.BindEnv("debugger-port", "KUBECTL_PLUGINS_LOCAL_FLAG_DEBUGGER_PORT")
 	_ = viper.BindPFlag("debugger-port", cmd.Flags().Lookup("debugger-port"))
 
+	cmd.Flags().IntVar(&dmmSettings.UserSpecifiedLocalPort, "local-port", 0,
+		"local port the debugger is forwarded to, a free port is picked when unset (optional)")
+	_ = viper.BindEnv("local-port", "KUBECTL_PLUGINS_LOCAL_FLAG_LOCAL_PORT")
+	_ = viper.BindPFlag("local-port", cmd.Flags().Lookup("local-port"))
+
 	cmd.Flags().BoolVarP(&dmmSettings.UserSpecifiedForceKill, "force-kill", "k", false,
 		"if specified, dmm will attempt to kill a remote dlv process and quit (optional)")
 	_ = viper.BindEnv("force-kill", "KUBECTL_PLUGINS_LOCAL_FLAG_FORCE_KILL")
 	_ = viper.BindPFlag("force-kill", cmd.Flags().Lookup("force-kill"))
 
+	cmd.Flags().BoolVar(&dmmSettings.UserSpecifiedRemoveDlv, "remove-dlv", false,
+		"if specified, the remote dlv binary is deleted once the debugger is killed (optional)")
+	_ = viper.BindEnv("remove-dlv", "KUBECTL_PLUGINS_LOCAL_FLAG_REMOVE_DLV")
+	_ = viper.BindPFlag("remove-dlv", cmd.Flags().Lookup("remove-dlv"))
+
+	cmd.Flags().DurationVar(&dmmSettings.UserSpecifiedTtl, "ttl", 0,
+		"if specified, dlv detaches from the process and exits after this duration, even if dmm can't stop it, e.g. 30m (optional)")
+	_ = viper.BindEnv("ttl", "KUBECTL_PLUGINS_LOCAL_FLAG_TTL")
+	_ = viper.BindPFlag("ttl", cmd.Flags().Lookup("ttl"))
+
+	cmd.Flags().DurationVar(&dmmSettings.UserSpecifiedIdleTimeout, "idle-timeout", 0,
+		"if specified, dlv detaches from the process and exits once no client has been connected to it for this duration, e.g. 10m (optional)")
+	_ = viper.BindEnv("idle-timeout", "KUBECTL_PLUGINS_LOCAL_FLAG_IDLE_TIMEOUT")
+	_ = viper.BindPFlag("idle-timeout", cmd.Flags().Lookup("idle-timeout"))
+
+	cmd.Flags().BoolVar(&dmmSettings.UserSpecifiedDetach, "detach", false,
+		"if specified, the debugger keeps running in its session when dmm is interrupted, see 'dmm session attach' and 'dmm session stop' (optional)")
+	_ = viper.BindEnv("detach", "KUBECTL_PLUGINS_LOCAL_FLAG_DETACH")
+	_ = viper.BindPFlag("detach", cmd.Flags().Lookup("detach"))
+
+	cmd.Flags().BoolVar(&dmmSettings.UserSpecifiedKeepLease, "keep-lease", false,
+		"if specified, the leader election lease held by the pod is renewed on its behalf while debugging (optional)")
+	_ = viper.BindEnv("keep-lease", "KUBECTL_PLUGINS_LOCAL_FLAG_KEEP_LEASE")
+	_ = viper.BindPFlag("keep-lease", cmd.Flags().Lookup("keep-lease"))
+
+	cmd.Flags().StringVar(&dmmSettings.UserSpecifiedLeaseName, "lease-name", "",
+		"name of the leader election lease to keep, detected from its holder identity when unset (optional)")
+	_ = viper.BindEnv("lease-name", "KUBECTL_PLUGINS_LOCAL_FLAG_LEASE_NAME")
+	_ = viper.BindPFlag("lease-name", cmd.Flags().Lookup("lease-name"))
+
+	cmd.Flags().BoolVar(&dmmSettings.UserSpecifiedDisableProbes, "disable-probes", false,
+		"if specified, the liveness probe of the container is removed from the workload owning the pod for the session, "+
+			"which replaces the pod (optional)")
+	_ = viper.BindEnv("disable-probes", "KUBECTL_PLUGINS_LOCAL_FLAG_DISABLE_PROBES")
+	_ = viper.BindPFlag("disable-probes", cmd.Flags().Lookup("disable-probes"))
+
+	cmd.Flags().BoolVar(&dmmSettings.UserSpecifiedSkipPreflight, "skip-preflight", false,
+		"if specified, failing preflight checks don't prevent debugging (optional)")
+	_ = viper.BindEnv("skip-preflight", "KUBECTL_PLUGINS_LOCAL_FLAG_SKIP_PREFLIGHT")
+	_ = viper.BindPFlag("skip-preflight", cmd.Flags().Lookup("skip-preflight"))
+
 	cmd.Flags().StringVarP((*string)(&dmmSettings.UserSpecifiedUploadMethod), "upload-method", "u", "direct",
-		"upload method for the debugger, 'direct' (default) requires 'tar' to be installed. 'stager' requires only curl to be installed.")
+		"upload method for the debugger, 'direct' (default) requires 'tar' to be installed. 'stager' requires only curl to be installed. "+
+			"'ephemeral' runs the debugger from an ephemeral container sharing the target container's processes. "+
+			"'chunked' streams the debugger through exec sessions and requires a shell with 'cat', 'dd' or 'base64'.")
 	_ = viper.BindPFlag("upload-method", cmd.Flags().Lookup("upload-method"))
 
+	cmd.Flags().StringVar(&dmmSettings.UserSpecifiedStagerImage, "stager-image", "",
+		"image of the stager pod, python:latest by default or busybox:latest with --builtin-stager (optional)")
+	_ = viper.BindEnv("stager-image", "KUBECTL_PLUGINS_LOCAL_FLAG_STAGER_IMAGE")
+	_ = viper.BindPFlag("stager-image", cmd.Flags().Lookup("stager-image"))
+
+	cmd.Flags().BoolVar(&dmmSettings.UserSpecifiedBuiltinStager, "builtin-stager", false,
+		"if specified, the stager pod serves the debugger with the plugin binary itself, its image then only needs 'sh', 'sleep' and 'cat' (optional)")
+	_ = viper.BindEnv("builtin-stager", "KUBECTL_PLUGINS_LOCAL_FLAG_BUILTIN_STAGER")
+	_ = viper.BindPFlag("builtin-stager", cmd.Flags().Lookup("builtin-stager"))
+
+	cmd.Flags().StringSliceVar(&dmmSettings.UserSpecifiedStagerSecrets, "stager-pull-secret", nil,
+		"image pull secret of the stager pod, can be repeated (optional)")
+	_ = viper.BindPFlag("stager-pull-secret", cmd.Flags().Lookup("stager-pull-secret"))
+
+	cmd.Flags().StringSliceVar(&dmmSettings.UserSpecifiedStagerNodes, "stager-node-selector", nil,
+		"node selector of the stager pod as key=value, can be repeated (optional)")
+	_ = viper.BindPFlag("stager-node-selector", cmd.Flags().Lookup("stager-node-selector"))
+
+	cmd.Flags().StringSliceVar(&dmmSettings.UserSpecifiedStagerTolerations, "stager-toleration", nil,
+		"toleration of the stager pod as key[=value]:effect, can be repeated (optional)")
+	_ = viper.BindPFlag("stager-toleration", cmd.Flags().Lookup("stager-toleration"))
+
+	cmd.Flags().StringVar(&dmmSettings.UserSpecifiedStagerCpu, "stager-cpu-limit", "",
+		"CPU limit (and request) of the stager pod, e.g. 100m (optional)")
+	_ = viper.BindPFlag("stager-cpu-limit", cmd.Flags().Lookup("stager-cpu-limit"))
+
+	cmd.Flags().StringVar(&dmmSettings.UserSpecifiedStagerMemory, "stager-memory-limit", "",
+		"memory limit (and request) of the stager pod, e.g. 64Mi (optional)")
+	_ = viper.BindPFlag("stager-memory-limit", cmd.Flags().Lookup("stager-memory-limit"))
+
+	cmd.Flags().Int64Var(&dmmSettings.UserSpecifiedStagerUser, "stager-run-as-user", 0,
+		"uid the stager pod runs as, 65534 (nobody) by default as the pod must not run as root (optional)")
+	_ = viper.BindPFlag("stager-run-as-user", cmd.Flags().Lookup("stager-run-as-user"))
+
+	cmd.Flags().DurationVar(&dmmSettings.UserSpecifiedStagerTimeout, "stager-timeout", kube.DefaultStagerTimeout,
+		"how long to wait for the stager pod to run and its service to be ready (optional)")
+	_ = viper.BindPFlag("stager-timeout", cmd.Flags().Lookup("stager-timeout"))
+
+	cmd.Flags().BoolVar(&dmmSettings.UserSpecifiedStagerPolicy, "stager-network-policy", false,
+		"if specified, a network policy allowing only the pod to debug to reach the stager pod is created for the upload (optional)")
+	_ = viper.BindPFlag("stager-network-policy", cmd.Flags().Lookup("stager-network-policy"))
+
+	cmd.Flags().StringVarP(&dmmSettings.UserSpecifiedImage, "image", "i", ephemeralImage,
+		"image of the ephemeral debugger container, must provide 'sleep', 'sh' and 'tar' unless it ships dlv at the remote dlv path (optional)")
+	_ = viper.BindEnv("image", "KUBECTL_PLUGINS_LOCAL_FLAG_IMAGE")
+	_ = viper.BindPFlag("image", cmd.Flags().Lookup("image"))
+
+	cmd.Flags().IntVar(&dmmSettings.UserSpecifiedPodIndex, "pod-index", -1,
+		"index of the pod to debug among the pods of a workload or selector, sorted by name. Prompts when unset and several pods match (optional)")
+	_ = viper.BindPFlag("pod-index", cmd.Flags().Lookup("pod-index"))
+
+	cmd.Flags().BoolVar(&dmmSettings.UserSpecifiedAllPods, "all", false,
+		"if specified, all the pods of a workload or selector are candidates for debugging, not only the ready ones. One of them is still debugged (optional)")
+	_ = viper.BindPFlag("all", cmd.Flags().Lookup("all"))
+
+	cmd.Flags().StringVarP(&dmmSettings.UserSpecifiedSelector, "selector", "l", "",
+		"label selector used to find the pod to debug, instead of a pod name (optional)")
+	_ = viper.BindPFlag("selector", cmd.Flags().Lookup("selector"))
+
+	cmd.Flags().BoolVar(&dmmSettings.UserSpecifiedFirst, "first", false,
+		"if specified, the first matching pod and container are selected instead of prompting (optional)")
+	_ = viper.BindPFlag("first", cmd.Flags().Lookup("first"))
+
+	cmd.AddCommand(NewCmdSession(dmm), NewCmdGc(dmm), NewCmdDoctor(dmm, cmd.Flags()), NewCmdStagerServe())
+
 	return cmd
 }
 
 func (o *DMM) Complete(cmd *cobra.Command, args []string) error {
 
-	if len(args) < minimumNumberOfArguments {
-		_ = cmd.Usage()
-		return errors.New("not enough arguments, pod name missing")
-	}
+	o.settings.UserSpecifiedSelector = viper.GetString("selector")
+
+	if o.settings.UserSpecifiedSelector != "" {
+		if len(args) > 0 {
+			return errors.New("a pod and a label selector cannot both be specified")
+		}
+	} else {
+		if len(args) < minimumNumberOfArguments {
+			_ = cmd.Usage()
+			return errors.New("not enough arguments, pod name missing")
+		}
 
-	o.settings.UserSpecifiedPodName = args[0]
-	if o.settings.UserSpecifiedPodName == "" {
-		return errors.New("pod name is empty")
+		if args[0] == "" {
+			return errors.New("pod name is empty")
+		}
+
+		kind, name, err := parseTarget(args[0])
+		if err != nil {
+			return err
+		}
+
+		if kind == podKind {
+			o.settings.UserSpecifiedPodName = name
+		} else {
+			o.settings.UserSpecifiedWorkloadKind = kind
+			o.settings.UserSpecifiedWorkloadName = name
+		}
 	}
 
 	o.settings.UserSpecifiedNamespace = viper.GetString("namespace")
 	o.settings.UserSpecifiedContainer = viper.GetString("container")
 	o.settings.UserSpecifiedPid = viper.GetInt("pid")
+	o.settings.UserSpecifiedProcessName = viper.GetString("process-name")
+	o.settings.UserSpecifiedExePath = viper.GetString("exe")
 	o.settings.UserSpecifiedVerboseMode = viper.GetBool("verbose")
 	o.settings.UserSpecifiedKubeContext = viper.GetString("context")
 	o.settings.UserSpecifiedLocalDlvPath = viper.GetString("local-dlv-path")
 	o.settings.UserSpecifiedRemoteDlvPath = viper.GetString("remote-dlv-path")
+	o.settings.UserSpecifiedRemoteDlvDirs = nil
+	for _, dirs := range viper.GetStringSlice("remote-dlv-dir") {
+		// the environment variable is a comma separated list too
+		for _, dir := range strings.Split(dirs, ",") {
+			if dir = strings.TrimSpace(dir); dir != "" {
+				o.settings.UserSpecifiedRemoteDlvDirs = append(o.settings.UserSpecifiedRemoteDlvDirs, dir)
+			}
+		}
+	}
 	o.settings.UserSpecifiedDebuggerPort = viper.GetInt("debugger-port")
+	o.settings.UserSpecifiedLocalPort = viper.GetInt("local-port")
 	o.settings.UserSpecifiedForceKill = viper.GetBool("force-kill")
+	o.settings.UserSpecifiedRemoveDlv = viper.GetBool("remove-dlv")
+	o.settings.UserSpecifiedKeepLease = viper.GetBool("keep-lease")
+	o.settings.UserSpecifiedDetach = viper.GetBool("detach")
+	o.settings.UserSpecifiedTtl = viper.GetDuration("ttl")
+	o.settings.UserSpecifiedIdleTimeout = viper.GetDuration("idle-timeout")
+	o.settings.UserSpecifiedLeaseName = viper.GetString("lease-name")
+	o.settings.UserSpecifiedDisableProbes = viper.GetBool("disable-probes")
+	o.settings.UserSpecifiedSkipPreflight = viper.GetBool("skip-preflight")
+	o.settings.UserSpecifiedPodIndex = viper.GetInt("pod-index")
+	o.settings.UserSpecifiedAllPods = viper.GetBool("all")
+	o.settings.UserSpecifiedFirst = viper.GetBool("first")
+	o.settings.UserSpecifiedImage = viper.GetString("image")
+	o.settings.UserSpecifiedStagerImage = viper.GetString("stager-image")
+	o.settings.UserSpecifiedBuiltinStager = viper.GetBool("builtin-stager")
+	o.settings.UserSpecifiedStagerSecrets = viper.GetStringSlice("stager-pull-secret")
+	o.settings.UserSpecifiedStagerNodes = viper.GetStringSlice("stager-node-selector")
+	o.settings.UserSpecifiedStagerTolerations = viper.GetStringSlice("stager-toleration")
+	o.settings.UserSpecifiedStagerCpu = viper.GetString("stager-cpu-limit")
+	o.settings.UserSpecifiedStagerMemory = viper.GetString("stager-memory-limit")
+	o.settings.UserSpecifiedStagerUser = viper.GetInt64("stager-run-as-user")
+	o.settings.UserSpecifiedStagerTimeout = viper.GetDuration("stager-timeout")
+	o.settings.UserSpecifiedStagerPolicy = viper.GetBool("stager-network-policy")
 	switch config.UploadMethod(viper.GetString("upload-method")) {
 	case config.DIRECT:
 		o.settings.UserSpecifiedUploadMethod = config.DIRECT
 	case config.STAGER:
 		o.settings.UserSpecifiedUploadMethod = config.STAGER
+	case config.EPHEMERAL:
+		o.settings.UserSpecifiedUploadMethod = config.EPHEMERAL
+	case config.CHUNKED:
+		o.settings.UserSpecifiedUploadMethod = config.CHUNKED
 	default:
 		return fmt.Errorf("unknown upload method: %s", config.UploadMethod(viper.GetString("upload-method")))
 	}
 
-	var err error
+	if o.settings.UserSpecifiedUploadMethod == config.STAGER {
+		if _, err := debugger.StagerConfig(o.settings); err != nil {
+			return err
+		}
+	}
 
-	if o.settings.UserSpecifiedVerboseMode {
-		log.Info("running in verbose mode")
-		log.SetLevel(log.DebugLevel)
+	processSelectors := 0
+	for _, isSet := range []bool{o.settings.UserSpecifiedPid != 0, o.settings.UserSpecifiedProcessName != "", o.settings.UserSpecifiedExePath != ""} {
+		if isSet {
+			processSelectors++
+		}
+	}
+
+	if processSelectors > 1 {
+		return errors.New("only one of --pid, --process-name and --exe can be specified")
+	}
+
+	if o.settings.UserSpecifiedTtl < 0 || o.settings.UserSpecifiedIdleTimeout < 0 {
+		return errors.New("--ttl and --idle-timeout must be positive, or 0 to disable them")
 	}
 
+	var err error
+
+	o.completeVerboseMode()
+
 	dlvLocalBinaryPathLookupList, err = o.buildDlvBinaryPathLookupList()
 	if err != nil {
 		return err
 	}
 
+	if err := o.completeKubeConfig(); err != nil {
+		return err
+	}
+
+	o.sessionRegistry, err = session.NewRegistry(o.clientset, o.contextName())
+
+	return err
+}
+
+func (o *DMM) completeVerboseMode() {
+	if o.settings.UserSpecifiedVerboseMode {
+		log.Info("running in verbose mode")
+		log.SetLevel(log.DebugLevel)
+	}
+}
+
+// completeKubeConfig loads the kubeconfig and builds the clients for the user
+// specified context and namespace.
+func (o *DMM) completeKubeConfig() error {
+	var err error
+
 	o.rawConfig, err = o.configFlags.ToRawKubeConfigLoader().RawConfig()
 	if err != nil {
 		return err
@@ -212,18 +479,100 @@ func (o *DMM) Complete(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// contextName returns the name of the kubeconfig context in use.
+func (o *DMM) contextName() string {
+	if o.settings.UserSpecifiedKubeContext != "" {
+		return o.settings.UserSpecifiedKubeContext
+	}
+
+	return o.rawConfig.CurrentContext
+}
+
+// detached tells whether the session was interrupted with --detach once the
+// debugger was running: the debugger then keeps running in the session, for
+// it to be attached again or stopped later, like the liveness probe stays
+// removed.
+func (o *DMM) detached(ctx context.Context) bool {
+	return ctx.Err() != nil && o.settings.UserSpecifiedDetach && o.debugSession.ID != ""
+}
+
+// sessionExpiry returns when the session started at startTime expires if dmm
+// goes away at now: session.HeartbeatExpiry later while attached,
+// session.DefaultExpiry later once detached, and when the watchdog of dlv
+// stops it with --ttl at the latest.
+func (o *DMM) sessionExpiry(startTime time.Time, now time.Time, detached bool) time.Time {
+	expiresAt := now.Add(session.HeartbeatExpiry)
+	if detached {
+		expiresAt = now.Add(session.DefaultExpiry)
+	}
+
+	if deadline := startTime.Add(o.settings.UserSpecifiedTtl); o.settings.UserSpecifiedTtl > 0 && deadline.Before(expiresAt) {
+		return deadline
+	}
+
+	return expiresAt
+}
+
+// keepSessionAlive pushes back the expiry of a session every
+// session.HeartbeatInterval until ctx is done.
+func (o *DMM) keepSessionAlive(ctx context.Context, s session.Session) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(session.HeartbeatInterval):
+		}
+
+		s.ExpiresAt = o.sessionExpiry(s.StartTime, time.Now(), false)
+		if err := o.sessionRegistry.Refresh(ctx, s); err != nil && ctx.Err() == nil {
+			log.WithError(err).Warnf("failed to refresh the expiry of session: '%s'", s.ID)
+		}
+	}
+}
+
+// detachSession gives a session left running the expiry of a detached one.
+func (o *DMM) detachSession(ctx context.Context, s session.Session) {
+	s.ExpiresAt = o.sessionExpiry(s.StartTime, time.Now(), true)
+	if err := o.sessionRegistry.Refresh(ctx, s); err != nil {
+		log.WithError(err).Warnf("failed to refresh the expiry of session: '%s'", s.ID)
+	}
+}
+
+// owner returns the kubeconfig user of the context as a label value, to record
+// who created the resources of a session.
+func (o *DMM) owner() string {
+	owner := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || strings.ContainsRune("-_.", r) {
+			return r
+		}
+		return '-'
+	}, o.resultingContext.AuthInfo)
+
+	if len(owner) > validation.LabelValueMaxLength {
+		owner = owner[:validation.LabelValueMaxLength]
+	}
+
+	owner = strings.Trim(owner, "-_.")
+	if len(validation.IsValidLabelValue(owner)) > 0 {
+		return ""
+	}
+
+	return owner
+}
+
 func (o *DMM) buildDlvBinaryPathLookupList() ([]string, error) {
 	dlvBinaryPath, err := filepath.EvalSymlinks(os.Args[0])
 	if err != nil {
 		return nil, err
 	}
 
-	dlvBinaryPath = filepath.Join(filepath.Dir(dlvBinaryPath), dlvBinaryName)
+	// the directory of the plugin, holding dlv or per-arch dlv-linux-<arch> builds
+	dlvBinaryDir := filepath.Dir(dlvBinaryPath)
 
-	return append([]string{o.settings.UserSpecifiedLocalDlvPath, dlvBinaryPath}), nil
+	return []string{o.settings.UserSpecifiedLocalDlvPath, dlvBinaryDir}, nil
 }
 
-func (o *DMM) Validate() error {
+func (o *DMM) Validate(ctx context.Context) error {
 	if len(o.rawConfig.CurrentContext) == 0 {
 		return errors.New("context doesn't exist")
 	}
@@ -234,14 +583,22 @@ func (o *DMM) Validate() error {
 
 	var err error
 
-	o.settings.UserSpecifiedLocalDlvPath, err = findLocalDlvBinaryPath()
+	if o.doctorMode || (!o.settings.UserSpecifiedForceKill && !o.settings.UserSpecifiedSkipPreflight) {
+		if err := o.reviewAccess(ctx); err != nil {
+			return err
+		}
+	}
+
+	if o.settings.UserSpecifiedWorkloadKind != "" {
+		o.settings.UserSpecifiedPodName, err = o.resolveWorkloadPod(ctx)
+	} else if o.settings.UserSpecifiedSelector != "" {
+		o.settings.UserSpecifiedPodName, err = o.resolveSelectorPod(ctx)
+	}
 	if err != nil {
 		return err
 	}
 
-	log.Infof("using dlv path at: '%s'", o.settings.UserSpecifiedLocalDlvPath)
-
-	pod, err := o.clientset.CoreV1().Pods(o.resultingContext.Namespace).Get(context.TODO(), o.settings.UserSpecifiedPodName, v1.GetOptions{})
+	pod, err := o.clientset.CoreV1().Pods(o.resultingContext.Namespace).Get(ctx, o.settings.UserSpecifiedPodName, v1.GetOptions{})
 	if err != nil {
 		return err
 	}
@@ -259,8 +616,19 @@ func (o *DMM) Validate() error {
 	}
 
 	if o.settings.UserSpecifiedContainer == "" {
-		log.Info("no container specified, taking first container we found in pod.")
-		o.settings.UserSpecifiedContainer = pod.Spec.Containers[0].Name
+		log.Info("no container specified, looking for containers in pod.")
+
+		var containers []string
+		for _, container := range pod.Spec.Containers {
+			containers = append(containers, fmt.Sprintf("%s (%s)", container.Name, container.Image))
+		}
+
+		index, err := o.pick("container", containers)
+		if err != nil {
+			return err
+		}
+
+		o.settings.UserSpecifiedContainer = pod.Spec.Containers[index].Name
 		log.Infof("selected container: '%s'", o.settings.UserSpecifiedContainer)
 	}
 
@@ -268,14 +636,78 @@ func (o *DMM) Validate() error {
 		return err
 	}
 
-	kubernetesApiService := kube.NewKubernetesApiService(o.clientset, o.restConfig, o.resultingContext.Namespace)
+	o.kubernetesApiService = kube.NewKubernetesApiService(o.clientset, o.restConfig, o.resultingContext.Namespace, o.streams.ErrOut)
 
 	if o.settings.UserSpecifiedDebuggerPort < 1024 || o.settings.UserSpecifiedDebuggerPort > 65535 {
 		return errors.New("Debugger port must be between 1024 and 65535")
 	}
 
+	if o.settings.UserSpecifiedLocalPort < 0 || o.settings.UserSpecifiedLocalPort > 65535 {
+		return errors.New("Local port must be between 1 and 65535, or 0 to pick a free port")
+	}
+
+	o.settings.DetectedRemoteDlvPath, err = o.selectRemoteDlvPath(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("using remote dlv path: '%s'", o.settings.DetectedRemoteDlvPath)
+
+	if err := o.ensureDebuggerContainer(ctx); err != nil {
+		return err
+	}
+
+	if !o.settings.UserSpecifiedForceKill {
+		o.settings.UserSpecifiedPid, err = o.resolvePid(ctx, o.kubernetesApiService)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := o.selectDlvBinary(ctx); err != nil {
+		return err
+	}
+
+	if o.doctorMode || (!o.settings.UserSpecifiedForceKill && !o.settings.UserSpecifiedSkipPreflight) {
+		if err := o.preflight(ctx, pod); err != nil {
+			return err
+		}
+	}
+
+	if o.doctorMode {
+		return nil
+	}
+
+	if !o.settings.UserSpecifiedForceKill {
+		if err := o.guardLivenessProbe(ctx, pod); err != nil {
+			return err
+		}
+	}
+
 	log.Info("debugging method: upload static dlv")
-	o.debuggerService = debugger.NewUploadDlvRemoteDebuggingService(o.settings, kubernetesApiService)
+	o.debuggerService = debugger.NewUploadDlvRemoteDebuggingService(o.settings, o.kubernetesApiService)
+
+	return nil
+}
+
+// selectDlvBinary selects the local dlv binary to upload, and the remote path
+// it is uploaded to.
+func (o *DMM) selectDlvBinary(ctx context.Context) error {
+	var err error
+
+	o.settings.UserSpecifiedLocalDlvPath, err = o.selectLocalDlvBinary(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("using dlv path at: '%s'", o.settings.UserSpecifiedLocalDlvPath)
+
+	sha, err := kube.FileSha256(o.settings.UserSpecifiedLocalDlvPath)
+	if err != nil {
+		return err
+	}
+
+	o.settings.DetectedCachedDlvPath = debugger.CachedDlvPath(o.settings.DetectedRemoteDlvPath, sha)
 
 	return nil
 }
@@ -296,73 +728,104 @@ func (o *DMM) findContainerId(pod *corev1.Pod) error {
 	return errors.Errorf("couldn't find container: '%s' in pod: '%s'", o.settings.UserSpecifiedContainer, o.settings.UserSpecifiedPodName)
 }
 
-func findLocalDlvBinaryPath() (string, error) {
-	log.Debugf("searching for dlv binary using lookup list: '%v'", dlvLocalBinaryPathLookupList)
-
-	for _, possibleDlvPath := range dlvLocalBinaryPathLookupList {
-		if _, err := os.Stat(possibleDlvPath); err == nil {
-			log.Debugf("dlv binary found at: '%s'", possibleDlvPath)
-
-			return possibleDlvPath, nil
-		}
-
-		log.Debugf("dlv binary was not found at: '%s'", possibleDlvPath)
-	}
-
-	return "", errors.Errorf("couldn't find dlv binary on any of: '%v'", dlvLocalBinaryPathLookupList)
-}
-
-func (o *DMM) Run() error {
+func (o *DMM) Run(ctx context.Context) error {
 	log.Infof("debugging on pod: '%s' [namespace: '%s', container: '%s', pid: '%d', port: '%d']",
 		o.settings.UserSpecifiedPodName, o.resultingContext.Namespace, o.settings.UserSpecifiedContainer, o.settings.UserSpecifiedPid, o.settings.UserSpecifiedDebuggerPort)
 
-	err := o.debuggerService.Setup()
+	// known before the setup, for the stager resources to be labeled with it
+	o.settings.DetectedSessionId = session.NewSessionId()
+	o.settings.DetectedOwner = o.owner()
+
+	err := o.debuggerService.Setup(ctx)
 	if err != nil {
 		return err
 	}
 
 	cleanupFunc := func() {
+		cleanupCtx, cancel := cleanupContext()
+		defer cancel()
+
+		if o.detached(ctx) {
+			log.Infof("interrupted, the debugger keeps running in session: '%s', see 'dmm session attach' and 'dmm session stop'", o.debugSession.ID)
+			o.detachSession(cleanupCtx, o.debugSession)
+			return
+		}
+
 		log.Info("starting debugger cleanup")
 
-		err := o.debuggerService.Cleanup()
-		if err != nil {
+		if o.settings.UserSpecifiedRemoveDlv && o.debugSession.ID != "" {
+			o.settings.DetectedDlvShared = o.dlvShared(cleanupCtx, o.debugSession)
+		}
+
+		err := o.debuggerService.Cleanup(cleanupCtx)
+		if errors.Is(err, debugger.ErrNotRunning) {
+			log.WithError(err).Info("the remote debugger already exited")
+		} else if err != nil {
 			log.WithError(err).Error("failed to teardown debugger, a manual teardown is required.")
 			return
 		}
 
 		log.Info("debugger cleanup completed successfully")
+
+		if o.debugSession.ID != "" {
+			if err := o.sessionRegistry.Unregister(cleanupCtx, o.debugSession); err != nil {
+				log.WithError(err).Warnf("failed to unregister debug session: '%s'", o.debugSession.ID)
+			}
+		}
 	}
 
 	if o.settings.UserSpecifiedForceKill {
-		log.Infof("Attempting to kill a remote dlv debugger by its path '%s'", o.settings.UserSpecifiedRemoteDlvPath)
-		cleanupFunc()
+		log.Infof("Attempting to kill a remote dlv debugger by its path '%s'", o.settings.DetectedRemoteDlvPath)
+		if !o.stopSessionsOfPod(ctx) {
+			// no session recorded, every dlv running the remote dlv path is
+			// looked up through /proc
+			o.settings.DetectedSessionId = ""
+			cleanupFunc()
+		}
+		// the liveness probe is restored on the way out, see restoreLivenessProbe
 		return nil
 	} else {
 		defer cleanupFunc()
 	}
 
-	log.Infof("starting kubectl port-forward on port %d", o.settings.UserSpecifiedDebuggerPort)
+	startTime := time.Now()
+	o.debugSession = session.Session{
+		ID:                o.settings.DetectedSessionId,
+		Namespace:         o.resultingContext.Namespace,
+		Pod:               o.settings.UserSpecifiedPodName,
+		Container:         o.settings.UserSpecifiedContainer,
+		DebuggerContainer: o.settings.DetectedDebuggerContainer,
+		Pid:               o.settings.UserSpecifiedPid,
+		RemoteDlvPath:     o.settings.DetectedRemoteDlvPath,
+		DlvPath:           o.settings.DetectedCachedDlvPath,
+		DebuggerPort:      o.settings.UserSpecifiedDebuggerPort,
+		StartTime:         startTime,
+		ExpiresAt:         o.sessionExpiry(startTime, startTime, false),
+		Owner:             o.settings.DetectedOwner,
+	}
 
-	// Resolves the kubeconfig file location for the port-forward
-	kubectlContext := "~/.kube/config"
+	if err := o.sessionRegistry.Register(ctx, o.debugSession); err != nil {
+		log.WithError(err).Warn("failed to register debug session")
+	}
 
-	if o.settings.UserSpecifiedKubeContext != "" {
-		kubectlContext = o.settings.UserSpecifiedKubeContext
-	} else if c, ok := os.LookupEnv("KUBECONFIG"); ok {
-		kubectlContext = c
+	if o.settings.UserSpecifiedKeepLease {
+		leaseKeeperService := lease.NewLeaseKeeperService(o.clientset, o.resultingContext.Namespace,
+			o.settings.UserSpecifiedLeaseName, o.settings.UserSpecifiedPodName)
+		if err := leaseKeeperService.Detect(ctx); err != nil {
+			return err
+		}
+
+		leaseCtx, stopLeaseKeeper := context.WithCancel(ctx)
+		defer stopLeaseKeeper()
+
+		go leaseKeeperService.Run(leaseCtx)
 	}
 
-	// Using kubectl here as to not have to rewrite the logic
-	cmd := exec.Command("kubectl",
-		"-n", o.settings.UserSpecifiedNamespace,
-		"--kubeconfig", kubectlContext, /* if we don't set this, it might default to some kubeconfig we don't expect */
-		"port-forward",
-		fmt.Sprintf("pod/%s", o.settings.UserSpecifiedPodName),
-		fmt.Sprintf("%d:%d", o.settings.UserSpecifiedDebuggerPort, o.settings.UserSpecifiedDebuggerPort))
+	log.Infof("starting port-forward to remote port %d", o.settings.UserSpecifiedDebuggerPort)
 
 	l := log.WithFields(log.Fields{
 		"remote": log.Fields{
-			"namespace": o.settings.UserSpecifiedNamespace,
+			"namespace": o.resultingContext.Namespace,
 			"pod":       o.settings.UserSpecifiedPodName,
 			"container": o.settings.UserSpecifiedContainer,
 			"pid":       o.settings.UserSpecifiedPid,
@@ -370,32 +833,102 @@ func (o *DMM) Run() error {
 		"port-forward": o.settings.UserSpecifiedDebuggerPort,
 	})
 
-	cmd.Stdout = l.Writer()
-	cmd.Stderr = l.Writer()
+	forwardCtx, stop := context.WithCancel(ctx)
+	defer stop()
 
-	if err != nil {
-		return err
-	}
+	go o.keepSessionAlive(forwardCtx, o.debugSession)
+
+	forwarderService := forwarder.NewForwarderService(o.kubernetesApiService, o.settings.UserSpecifiedPodName,
+		o.settings.UserSpecifiedLocalPort, o.settings.UserSpecifiedDebuggerPort, l.Writer())
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- forwarderService.Run(forwardCtx)
+	}()
 
 	go func() {
 		l := log.WithFields(log.Fields{
-			"namespace": o.settings.UserSpecifiedNamespace,
+			"namespace": o.resultingContext.Namespace,
 			"pod":       o.settings.UserSpecifiedPodName,
 			"container": o.settings.UserSpecifiedContainer,
 			"pid":       o.settings.UserSpecifiedPid,
 			"dlv":       o.settings.UserSpecifiedDebuggerPort,
 		})
-		err := o.debuggerService.Start(l.Writer())
+		err := o.debuggerService.Start(forwardCtx, l.Writer())
+		if forwardCtx.Err() != nil {
+			return
+		}
+		if errors.Is(err, debugger.ErrLogStreamLost) {
+			log.WithError(err).Warn("the remote debugger keeps running, its logs won't be displayed anymore")
+			return
+		}
 		if err != nil {
 			log.WithError(err).Errorf("failed to start remote debugging, stopping port-forward")
-			_ = cmd.Process.Kill()
+		} else {
+			log.Info("remote debugger exited, stopping port-forward")
 		}
+		stop()
 	}()
 
-	err = cmd.Run()
+	select {
+	case localPort := <-forwarderService.Ready():
+		if err := o.printListenAddress(o.debugSession, localPort); err != nil {
+			stop()
+			return err
+		}
+	case err := <-errCh:
+		return err
+	}
+
+	return <-errCh
+}
+
+// printListenAddress writes the local address of the debugger as a single JSON
+// line on stdout, logs going to stderr, for scripts and IDE integrations.
+func (o *DMM) printListenAddress(s session.Session, localPort int) error {
+	address := fmt.Sprintf("127.0.0.1:%d", localPort)
+
+	log.Infof("port-forward ready, the debugger is reachable on %s", address)
+
+	line, err := json.Marshal(map[string]interface{}{
+		"session":    s.ID,
+		"address":    address,
+		"localPort":  localPort,
+		"remotePort": s.DebuggerPort,
+		"namespace":  s.Namespace,
+		"pod":        s.Pod,
+		"container":  s.Container,
+		"pid":        s.Pid,
+	})
 	if err != nil {
 		return err
 	}
 
-	return nil
+	_, err = fmt.Fprintln(o.streams.Out, string(line))
+
+	return err
+}
+
+// stopSessionsOfPod stops the sessions of the pod for the remote dlv path,
+// each killing its own debugger only, and tells whether there was any.
+func (o *DMM) stopSessionsOfPod(ctx context.Context) bool {
+	sessions, err := o.sessionRegistry.List(ctx, o.resultingContext.Namespace)
+	if err != nil {
+		log.WithError(err).Warn("failed to list debug sessions")
+		return false
+	}
+
+	var found bool
+	for _, s := range sessions {
+		if s.Pod != o.settings.UserSpecifiedPodName || s.RemoteDlvPath != o.settings.DetectedRemoteDlvPath {
+			continue
+		}
+
+		found = true
+		if err := o.stopDebugSession(ctx, s, o.settings.UserSpecifiedRemoveDlv); err != nil {
+			log.WithError(err).Errorf("failed to stop debug session: '%s', a manual teardown is required.", s.ID)
+		}
+	}
+
+	return found
 }
@@ -0,0 +1,248 @@
+package cmd
+
+import (
+	"context"
+	"debug-me-maybe/kube"
+	"debug-me-maybe/pkg/arch"
+	"debug-me-maybe/pkg/config"
+	"debug-me-maybe/pkg/service/preflight"
+	"debug-me-maybe/pkg/service/toolchain"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// selectLocalDlvBinary returns the local dlv binary to upload: one built for
+// the architecture of the pod, supporting the Go version the process to debug
+// was built with.
+func (o *DMM) selectLocalDlvBinary(ctx context.Context) (string, error) {
+	goarch, err := o.detectArchitecture(ctx)
+	if err != nil {
+		log.WithError(err).Warn("failed to detect the pod architecture")
+	}
+
+	o.settings.DetectedArchitecture = goarch
+
+	candidates, err := findLocalDlvBinaryPaths(goarch)
+	if err != nil {
+		return "", err
+	}
+
+	if o.settings.UserSpecifiedPid == 0 {
+		return candidates[0], nil
+	}
+
+	toolchainService := toolchain.NewToolchainService(o.kubernetesApiService, o.settings.UserSpecifiedPodName, o.inspectionContainer())
+
+	info, err := toolchainService.ReadBuildInfo(ctx, o.settings.UserSpecifiedPid)
+	if err != nil {
+		log.WithError(err).Warnf("failed to read the build info of pid: '%d', skipping the Go version check", o.settings.UserSpecifiedPid)
+		return candidates[0], nil
+	}
+
+	log.Infof("pid: '%d' was built with: %s", o.settings.UserSpecifiedPid, toolchain.DescribeBuildInfo(info))
+
+	o.settings.DetectedGoVersion = info.GoVersion
+
+	var versions []string
+	for _, candidate := range candidates {
+		version, err := toolchain.DelveVersion(candidate)
+		if err != nil {
+			log.WithError(err).Debugf("unknown Delve version of: '%s'", candidate)
+		}
+
+		if toolchain.Supports(version, info.GoVersion) {
+			o.settings.DetectedDelveVersion = version
+			return candidate, nil
+		}
+
+		log.Debugf("dlv binary at: '%s' (%s) doesn't support: '%s'", candidate, toolchain.DescribeSupport(version), info.GoVersion)
+		versions = append(versions, fmt.Sprintf("%s (%s)", candidate, toolchain.DescribeSupport(version)))
+	}
+
+	err = errors.Errorf("none of the dlv binaries supports %s: %v, build dlv with a Delve release supporting it and name it '%s-<version>-linux-%s'",
+		info.GoVersion, versions, dlvBinaryName, goarch)
+
+	if !o.doctorMode && !o.settings.UserSpecifiedSkipPreflight {
+		return "", err
+	}
+
+	log.WithError(err).Warn("using an unsupported dlv binary")
+
+	o.settings.DetectedDelveVersion, _ = toolchain.DelveVersion(candidates[0])
+
+	return candidates[0], nil
+}
+
+// selectRemoteDlvPath returns the remote dlv path: the one specified, else in
+// the first candidate directory that is writable and allows execution, or in
+// such a mount of the container when none of them does.
+func (o *DMM) selectRemoteDlvPath(ctx context.Context) (string, error) {
+	if o.settings.UserSpecifiedRemoteDlvPath != "" {
+		return o.settings.UserSpecifiedRemoteDlvPath, nil
+	}
+
+	if len(o.settings.UserSpecifiedRemoteDlvDirs) == 0 {
+		return "", errors.New("no remote directory for dlv specified")
+	}
+
+	fallback := path.Join(o.settings.UserSpecifiedRemoteDlvDirs[0], dlvBinaryName)
+
+	// dlv runs in the debugger ephemeral container, its image provides /tmp
+	if o.settings.UserSpecifiedUploadMethod == config.EPHEMERAL {
+		return fallback, nil
+	}
+
+	remoteDirectoryService := preflight.NewRemoteDirectoryService(o.kubernetesApiService, o.settings.UserSpecifiedPodName, o.settings.UserSpecifiedContainer)
+
+	directories, err := remoteDirectoryService.Probe(ctx, o.settings.UserSpecifiedRemoteDlvDirs)
+	if err != nil {
+		log.WithError(err).Warnf("failed to probe the remote directories, using: '%s'", fallback)
+		return fallback, nil
+	}
+
+	var rejections []string
+	for _, directory := range directories {
+		if directory.Rejection == "" {
+			if len(rejections) > 0 {
+				log.Warnf("rejected remote directories: %s, using: '%s'", strings.Join(rejections, ", "), directory.Path)
+			}
+
+			return path.Join(directory.Path, dlvBinaryName), nil
+		}
+
+		log.Debugf("remote directory: '%s' rejected: %s", directory.Path, directory.Rejection)
+
+		if !directory.Discovered {
+			rejections = append(rejections, fmt.Sprintf("'%s' (%s)", directory.Path, directory.Rejection))
+		}
+	}
+
+	if o.doctorMode || o.settings.UserSpecifiedSkipPreflight {
+		log.Warnf("rejected remote directories: %s, using: '%s' anyway", strings.Join(rejections, ", "), fallback)
+		return fallback, nil
+	}
+
+	return "", errors.Errorf("no writable remote directory allowing execution found, rejected: %s; mount an emptyDir "+
+		"volume in the container and pass it with '--remote-dlv-dir'", strings.Join(rejections, ", "))
+}
+
+// detectArchitecture returns the GOARCH of the node running the pod, or of the
+// container when the node can't be read.
+func (o *DMM) detectArchitecture(ctx context.Context) (string, error) {
+	node, err := o.clientset.CoreV1().Nodes().Get(ctx, o.settings.DetectedPodNodeName, v1.GetOptions{})
+	if err == nil && node.Status.NodeInfo.Architecture != "" {
+		log.Infof("node: '%s' architecture: '%s'", node.Name, node.Status.NodeInfo.Architecture)
+		return node.Status.NodeInfo.Architecture, nil
+	}
+
+	log.WithError(err).Debugf("failed to get architecture of node: '%s', falling back to uname", o.settings.DetectedPodNodeName)
+
+	output := new(kube.Writer)
+	exitCode, err := o.kubernetesApiService.ExecuteCommand(ctx, o.settings.UserSpecifiedPodName, o.inspectionContainer(),
+		[]string{"uname", "-m"}, output)
+	if err != nil || exitCode != 0 {
+		return "", errors.Errorf("failed to detect the architecture of pod: '%s', exitCode: '%d'", o.settings.UserSpecifiedPodName, exitCode)
+	}
+
+	goarch, err := arch.FromUname(output.Output)
+	if err != nil {
+		return "", err
+	}
+
+	log.Infof("container: '%s' architecture: '%s'", o.settings.UserSpecifiedContainer, goarch)
+
+	return goarch, nil
+}
+
+// findLocalDlvBinaryPaths returns the dlv binaries of the lookup list built for
+// goarch. Directories of the list are searched for a per-arch build
+// (dlv-linux-<arch>), a plain dlv, then versioned per-arch builds
+// (dlv-<version>-linux-<arch>), newest first. When goarch is unknown, the
+// first dlv binary found is returned without checking its architecture.
+func findLocalDlvBinaryPaths(goarch string) ([]string, error) {
+	log.Debugf("searching for dlv binary for architecture: '%s' using lookup list: '%v'", goarch, dlvLocalBinaryPathLookupList)
+
+	var found, tried []string
+	for _, possibleDlvPath := range dlvLocalBinaryPathLookupList {
+		info, err := os.Stat(possibleDlvPath)
+		if err != nil {
+			log.Debugf("dlv binary was not found at: '%s'", possibleDlvPath)
+			continue
+		}
+
+		candidates := []string{possibleDlvPath}
+		if info.IsDir() {
+			candidates = []string{filepath.Join(possibleDlvPath, dlvBinaryName)}
+			if goarch != "" {
+				candidates = append([]string{filepath.Join(possibleDlvPath, dlvBinaryName+"-linux-"+goarch)}, candidates...)
+				candidates = append(candidates, versionedDlvBinaryPaths(possibleDlvPath, goarch)...)
+			}
+		}
+
+		for _, candidate := range candidates {
+			if info, err := os.Stat(candidate); err != nil || info.IsDir() {
+				log.Debugf("dlv binary was not found at: '%s'", candidate)
+				continue
+			}
+
+			if goarch == "" {
+				log.Warnf("unknown pod architecture, using dlv binary at: '%s' unchecked", candidate)
+				return []string{candidate}, nil
+			}
+
+			binaryArch, err := arch.FromELF(candidate)
+			if err != nil {
+				log.WithError(err).Debugf("skipping dlv binary at: '%s'", candidate)
+				tried = append(tried, fmt.Sprintf("%s (not a linux binary)", candidate))
+				continue
+			}
+
+			if binaryArch != goarch {
+				log.Warnf("skipping dlv binary at: '%s', built for: '%s' instead of: '%s'", candidate, binaryArch, goarch)
+				tried = append(tried, fmt.Sprintf("%s (%s)", candidate, binaryArch))
+				continue
+			}
+
+			log.Debugf("dlv binary found at: '%s'", candidate)
+			found = append(found, candidate)
+		}
+	}
+
+	if len(found) > 0 {
+		return found, nil
+	}
+
+	if goarch == "" {
+		return nil, errors.Errorf("couldn't find dlv binary on any of: '%v'", dlvLocalBinaryPathLookupList)
+	}
+
+	return nil, errors.Errorf("couldn't find a linux/%s dlv binary on any of: '%v' (found: %v), build one with "+
+		"'CGO_ENABLED=0 GOOS=linux GOARCH=%s' and name it '%s-linux-%s'",
+		goarch, dlvLocalBinaryPathLookupList, tried, goarch, dlvBinaryName, goarch)
+}
+
+// versionedDlvBinaryPaths returns the dlv-<version>-linux-<arch> binaries of
+// a directory, the ones supporting the most recent Go versions first.
+func versionedDlvBinaryPaths(directory string, goarch string) []string {
+	paths, _ := filepath.Glob(filepath.Join(directory, dlvBinaryName+"-v*-linux-"+goarch))
+
+	maxGo := func(path string) int {
+		version := filepath.Base(path)[len(dlvBinaryName)+1:]
+		_, max, _ := toolchain.SupportedGoVersions(version)
+		return max
+	}
+
+	sort.SliceStable(paths, func(i, j int) bool {
+		return maxGo(paths[i]) > maxGo(paths[j])
+	})
+
+	return paths
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeElf writes an ELF header of a binary built for machine.
+func writeElf(t *testing.T, path string, machine elf.Machine) {
+	t.Helper()
+
+	header := elf.Header64{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(machine),
+		Version:   uint32(elf.EV_CURRENT),
+		Ehsize:    64,
+		Phentsize: 56,
+		Shentsize: 64,
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if err := binary.Write(file, binary.LittleEndian, header); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVersionedDlvBinaryPaths(t *testing.T) {
+	directory := t.TempDir()
+
+	for _, name := range []string{"dlv-v1.21.2-linux-amd64", "dlv-v1.9.1-linux-amd64", "dlv-v1.23.1-linux-amd64",
+		"dlv-v1.24.0-linux-arm64", "dlv-linux-amd64", "dlv"} {
+		writeElf(t, filepath.Join(directory, name), elf.EM_X86_64)
+	}
+
+	want := []string{
+		filepath.Join(directory, "dlv-v1.23.1-linux-amd64"),
+		filepath.Join(directory, "dlv-v1.21.2-linux-amd64"),
+		filepath.Join(directory, "dlv-v1.9.1-linux-amd64"),
+	}
+
+	if paths := versionedDlvBinaryPaths(directory, "amd64"); !reflect.DeepEqual(paths, want) {
+		t.Errorf("versionedDlvBinaryPaths(%q, %q) = %q, want %q", directory, "amd64", paths, want)
+	}
+}
+
+func TestFindLocalDlvBinaryPaths(t *testing.T) {
+	directory := t.TempDir()
+	writeElf(t, filepath.Join(directory, "dlv-linux-amd64"), elf.EM_X86_64)
+	writeElf(t, filepath.Join(directory, "dlv"), elf.EM_AARCH64)
+	writeElf(t, filepath.Join(directory, "dlv-v1.21.2-linux-amd64"), elf.EM_X86_64)
+	writeElf(t, filepath.Join(directory, "dlv-v1.23.1-linux-amd64"), elf.EM_X86_64)
+	// misnamed, built for arm64
+	writeElf(t, filepath.Join(directory, "dlv-v1.22.0-linux-amd64"), elf.EM_AARCH64)
+
+	other := t.TempDir()
+	custom := filepath.Join(other, "dlv-custom")
+	writeElf(t, custom, elf.EM_PPC64)
+	script := filepath.Join(other, "dlv-script")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		lookupList []string
+		goarch     string
+		paths      []string
+	}{
+		{name: "per-arch, then versioned, newest first", lookupList: []string{directory}, goarch: "amd64",
+			paths: []string{
+				filepath.Join(directory, "dlv-linux-amd64"),
+				filepath.Join(directory, "dlv-v1.23.1-linux-amd64"),
+				filepath.Join(directory, "dlv-v1.21.2-linux-amd64"),
+			}},
+		{name: "plain dlv", lookupList: []string{directory}, goarch: "arm64",
+			paths: []string{filepath.Join(directory, "dlv")}},
+		{name: "unknown architecture", lookupList: []string{directory}, goarch: "",
+			paths: []string{filepath.Join(directory, "dlv")}},
+		{name: "missing paths skipped", lookupList: []string{filepath.Join(other, "missing"), custom, directory}, goarch: "ppc64le",
+			paths: []string{custom}},
+		{name: "other architectures skipped", lookupList: []string{custom, directory}, goarch: "arm64",
+			paths: []string{filepath.Join(directory, "dlv")}},
+		{name: "no binary for architecture", lookupList: []string{directory, custom}, goarch: "s390x"},
+		{name: "not a linux binary", lookupList: []string{script}, goarch: "amd64"},
+	}
+
+	defer func(lookupList []string) {
+		dlvLocalBinaryPathLookupList = lookupList
+	}(dlvLocalBinaryPathLookupList)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dlvLocalBinaryPathLookupList = test.lookupList
+
+			paths, err := findLocalDlvBinaryPaths(test.goarch)
+			if (err == nil) != (test.paths != nil) {
+				t.Fatalf("findLocalDlvBinaryPaths(%q) error = %v, want found: %t", test.goarch, err, test.paths != nil)
+			}
+
+			if !reflect.DeepEqual(paths, test.paths) {
+				t.Errorf("findLocalDlvBinaryPaths(%q) = %q, want %q", test.goarch, paths, test.paths)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"context"
+	"debug-me-maybe/pkg/service/preflight"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func NewCmdDoctor(dmm *DMM, flags *pflag.FlagSet) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "doctor (POD | TYPE/NAME | -l selector) [-n namespace] [-c container] [-P pid]",
+		Short:        "Check whether a process can be debugged, without uploading anything.",
+		Args:         cobra.ArbitraryArgs,
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			dmm.doctorMode = true
+
+			if err := dmm.Complete(c, args); err != nil {
+				return err
+			}
+
+			return dmm.Validate(c.Context())
+		},
+	}
+
+	// the same flags as the root command, to check what the root command does
+	cmd.Flags().AddFlagSet(flags)
+
+	return cmd
+}
+
+// preflight checks that the debugger can be uploaded and attached. The checks
+// are printed in doctor mode, and only when one of them fails otherwise.
+func (o *DMM) preflight(ctx context.Context, pod *corev1.Pod) error {
+	checks := preflight.NewPreflightService(o.settings, o.kubernetesApiService, pod).Run(ctx)
+	if o.accessCheck != nil {
+		checks = append([]preflight.Check{*o.accessCheck}, checks...)
+	}
+
+	failed := preflight.HasFailures(checks)
+
+	if o.doctorMode {
+		if err := preflight.PrintChecks(o.streams.Out, checks); err != nil {
+			return err
+		}
+	} else if failed {
+		if err := preflight.PrintChecks(o.streams.ErrOut, checks); err != nil {
+			return err
+		}
+	}
+
+	for _, check := range checks {
+		log.Debugf("preflight check '%s': %s (%s)", check.Name, check.Status, check.Detail)
+	}
+
+	if failed {
+		return errors.New("preflight checks failed, use --skip-preflight to ignore them")
+	}
+
+	return nil
+}
+
+// reviewAccess checks that the user has the permissions the session needs in
+// the namespace, before anything is done there. In doctor mode, the check is
+// reported along with the other preflight checks instead.
+func (o *DMM) reviewAccess(ctx context.Context) error {
+	permissions := preflight.RequiredPermissions(o.settings)
+	check := preflight.NewAccessReviewService(o.clientset, o.resultingContext.Namespace, permissions).Run(ctx)
+
+	if o.doctorMode {
+		o.accessCheck = &check
+		return nil
+	}
+
+	if check.Status == preflight.FAIL {
+		return errors.Errorf("RBAC permissions %s, use --skip-preflight to ignore them", check.Detail)
+	}
+
+	return nil
+}
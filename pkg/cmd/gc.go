@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"context"
+	"debug-me-maybe/kube"
+	"debug-me-maybe/pkg/service/gc"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func NewCmdGc(dmm *DMM) *cobra.Command {
+	var allNamespaces bool
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:          "gc [-n namespace] [-A] [--dry-run]",
+		Short:        "Remove the expired stager resources and debuggers left behind by killed sessions.",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := dmm.completeSession(); err != nil {
+				return err
+			}
+
+			namespace := dmm.resultingContext.Namespace
+			if allNamespaces {
+				namespace = corev1.NamespaceAll
+			}
+
+			return dmm.gc(c.Context(), namespace, dryRun)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&allNamespaces, "all-namespaces", "A", false,
+		"if specified, collect the expired resources of all namespaces (optional)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false,
+		"if specified, only print what would be removed (optional)")
+
+	return cmd
+}
+
+// gc removes the stager pods, services and network policies that outlived
+// their upload, and kills the debuggers of the expired sessions.
+func (o *DMM) gc(ctx context.Context, namespace string, dryRun bool) error {
+	now := time.Now()
+
+	suffix := ""
+	if dryRun {
+		suffix = " (dry run)"
+	}
+
+	gcService := gc.NewGcService(kube.NewKubernetesApiService(o.clientset, o.restConfig, namespace, o.streams.ErrOut))
+
+	resources, err := gcService.ExpiredStagerResources(ctx, now)
+	if err != nil {
+		return errors.Wrap(err, "failed to list the stager resources")
+	}
+
+	var failed int
+
+	for _, resource := range resources {
+		if !dryRun {
+			if err := gcService.Delete(ctx, resource); err != nil {
+				log.WithError(err).Errorf("failed to delete %s: '%s' [namespace: '%s']", resource.Kind, resource.Name, resource.Namespace)
+				failed++
+				continue
+			}
+		}
+
+		_, _ = fmt.Fprintf(o.streams.Out, "%s/%s deleted [namespace: '%s', session: '%s', owner: '%s', expired: %s]%s\n",
+			resource.Kind, resource.Name, resource.Namespace, resource.Session, resource.Owner, resource.ExpiresAt.Format(time.RFC3339), suffix)
+	}
+
+	sessions, err := o.sessionRegistry.List(ctx, namespace)
+	if err != nil {
+		return errors.Wrap(err, "failed to list the debug sessions")
+	}
+
+	for _, s := range sessions {
+		if !s.Expired(now) {
+			continue
+		}
+
+		if !dryRun {
+			if err := o.stopDebugSession(ctx, s, true); err != nil {
+				log.WithError(err).Errorf("failed to stop expired session: '%s'", s.ID)
+				failed++
+				continue
+			}
+		}
+
+		_, _ = fmt.Fprintf(o.streams.Out, "session/%s stopped [namespace: '%s', pod: '%s', owner: '%s', expired: %s]%s\n",
+			s.ID, s.Namespace, s.Pod, s.Owner, s.ExpiresAt.Format(time.RFC3339), suffix)
+	}
+
+	if failed > 0 {
+		return errors.Errorf("failed to remove %d expired resources", failed)
+	}
+
+	return nil
+}
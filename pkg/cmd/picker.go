@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// isInteractive reports whether both ends of the user's streams are terminals,
+// in which case it is fine to prompt.
+func (o *DMM) isInteractive() bool {
+	in, ok := o.streams.In.(*os.File)
+	if !ok {
+		return false
+	}
+
+	out, ok := o.streams.ErrOut.(*os.File)
+	if !ok {
+		return false
+	}
+
+	return isTerminal(in) && isTerminal(out)
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// pick returns the index of the option chosen among options. A single option
+// is returned as is, --first selects the first one, otherwise the user is
+// prompted when running in a terminal.
+func (o *DMM) pick(what string, options []string) (int, error) {
+	if len(options) == 0 {
+		return 0, errors.Errorf("no %s to pick from", what)
+	}
+
+	if len(options) == 1 {
+		return 0, nil
+	}
+
+	if o.settings.UserSpecifiedFirst {
+		log.Infof("%d %ss match, taking the first one: '%s'", len(options), what, options[0])
+		return 0, nil
+	}
+
+	if !o.isInteractive() {
+		return 0, errors.Errorf("%d %ss match: '%s', be more specific or use --first",
+			len(options), what, strings.Join(options, "', '"))
+	}
+
+	_, _ = fmt.Fprintf(o.streams.ErrOut, "%d %ss match:\n", len(options), what)
+	for i, option := range options {
+		_, _ = fmt.Fprintf(o.streams.ErrOut, "  [%d] %s\n", i+1, option)
+	}
+
+	reader := bufio.NewReader(o.streams.In)
+	for {
+		_, _ = fmt.Fprintf(o.streams.ErrOut, "select a %s [1-%d]: ", what, len(options))
+
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to read %s selection", what)
+		}
+
+		choice, err := strconv.Atoi(strings.TrimSpace(line))
+		if err == nil && choice >= 1 && choice <= len(options) {
+			return choice - 1, nil
+		}
+
+		_, _ = fmt.Fprintf(o.streams.ErrOut, "invalid selection: '%s'\n", strings.TrimSpace(line))
+	}
+}
+
+// confirm asks a yes/no question, defaulting to no. It must only be called
+// when running interactively.
+func (o *DMM) confirm(question string) (bool, error) {
+	_, _ = fmt.Fprintf(o.streams.ErrOut, "%s [y/N]: ", question)
+
+	line, err := bufio.NewReader(o.streams.In).ReadString('\n')
+	if err != nil {
+		return false, errors.Wrap(err, "failed to read answer")
+	}
+
+	answer := strings.ToLower(strings.TrimSpace(line))
+
+	return answer == "y" || answer == "yes", nil
+}
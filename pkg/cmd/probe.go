@@ -0,0 +1,289 @@
+package cmd
+
+import (
+	"context"
+	"debug-me-maybe/pkg/service/probe"
+	"debug-me-maybe/pkg/service/process"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var probeOwnerKinds = map[string]string{
+	probe.DeploymentKind:  deploymentKind,
+	probe.StatefulSetKind: statefulSetKind,
+	probe.DaemonSetKind:   daemonSetKind,
+}
+
+// guardLivenessProbe warns about the time the process can stay halted before
+// the liveness probe of the container gets it restarted, and offers to remove
+// the probe from the owning workload for the duration of the session. The pod
+// is then replaced by the rollout, and the session targets the pod replacing
+// it. It is the last step of the validation, for a session failing its checks
+// not to roll the workload out.
+func (o *DMM) guardLivenessProbe(ctx context.Context, pod *corev1.Pod) error {
+	var container *corev1.Container
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name == o.settings.UserSpecifiedContainer {
+			container = &pod.Spec.Containers[i]
+		}
+	}
+
+	if container == nil {
+		return nil
+	}
+
+	if container.StartupProbe != nil {
+		log.Debugf("container '%s' has a startup probe, it doesn't matter once the container started", container.Name)
+	}
+
+	budget, found := probe.Budget(container)
+	if !found {
+		return nil
+	}
+
+	probeGuardService := probe.NewProbeGuardService(o.clientset, o.resultingContext.Namespace, container.Name)
+
+	if err := probeGuardService.FindOwner(ctx, pod); err != nil {
+		if !errors.Is(err, probe.ErrNoWorkload) && o.settings.UserSpecifiedDisableProbes {
+			return err
+		}
+
+		log.WithError(err).Warnf("container '%s' has a liveness probe: halting the process for more than %s gets the container "+
+			"restarted by the kubelet, ending the debug session", container.Name, budget)
+		return nil
+	}
+
+	disable := o.settings.UserSpecifiedDisableProbes
+	if !disable {
+		log.Warnf("container '%s' has a liveness probe: halting the process for more than %s gets the container restarted "+
+			"by the kubelet, ending the debug session. Use --disable-probes to remove it for the session", container.Name, budget)
+
+		if o.isInteractive() {
+			var err error
+			disable, err = o.confirm("remove the liveness probe from the workload owning the pod for the session? (pods are replaced)")
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if !disable {
+		return nil
+	}
+
+	o.probeGuardService = probeGuardService
+
+	if err := probeGuardService.Disable(ctx, container.LivenessProbe); err != nil {
+		return err
+	}
+
+	replacement, err := o.replacementPod(ctx, pod)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("pod '%s' was replaced by pod '%s' on node '%s'", pod.Name, replacement.Name, replacement.Spec.NodeName)
+
+	return o.retarget(ctx, replacement)
+}
+
+// replacementPod returns the pod replacing the debugged one once the workload
+// owning it rolled out: the pod with the same name, hence the same ordinal,
+// for a statefulset, the pod on the same node for a daemonset, and for a
+// deployment one on the same node when there is one.
+func (o *DMM) replacementPod(ctx context.Context, previous *corev1.Pod) (*corev1.Pod, error) {
+	ownerKind, ownerName := o.probeGuardService.Owner()
+
+	selector, owners, err := o.workloadSelector(ctx, probeOwnerKinds[ownerKind], ownerName)
+	if err != nil {
+		return nil, err
+	}
+
+	pods, err := o.clientset.CoreV1().Pods(o.resultingContext.Namespace).List(ctx, v1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(pods.Items, func(i, j int) bool {
+		return pods.Items[i].Name < pods.Items[j].Name
+	})
+
+	var fallback *corev1.Pod
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.UID == previous.UID || pod.DeletionTimestamp != nil || !isPodReady(pod) {
+			continue
+		}
+
+		if controller := v1.GetControllerOf(pod); controller == nil || !owners[controller.UID] {
+			continue
+		}
+
+		switch {
+		case ownerKind == probe.StatefulSetKind:
+			if pod.Name == previous.Name {
+				return pod, nil
+			}
+		case pod.Spec.NodeName == previous.Spec.NodeName:
+			return pod, nil
+		case ownerKind == probe.DeploymentKind && fallback == nil:
+			fallback = pod
+		}
+	}
+
+	if fallback == nil {
+		return nil, errors.Errorf("no ready pod of %s '%s' replacing pod '%s' found", ownerKind, ownerName, previous.Name)
+	}
+
+	log.Warnf("no pod of %s '%s' replacing pod '%s' on node '%s', using pod '%s' on node '%s'",
+		ownerKind, ownerName, previous.Name, previous.Spec.NodeName, fallback.Name, fallback.Spec.NodeName)
+
+	return fallback, nil
+}
+
+// retarget points the session to the pod replacing the debugged one. The
+// process is looked up again by its executable, its pid may differ, and the
+// dlv binary is selected again when the pod runs on another node.
+func (o *DMM) retarget(ctx context.Context, pod *corev1.Pod) error {
+	o.settings.UserSpecifiedPodName = pod.Name
+
+	if err := o.findContainerId(pod); err != nil {
+		return err
+	}
+
+	// the debugger ephemeral container went away with the previous pod
+	o.settings.DetectedDebuggerContainer = ""
+	if err := o.ensureDebuggerContainer(ctx); err != nil {
+		return err
+	}
+
+	if o.settings.DetectedProcessExe != "" {
+		processes, err := process.NewProcessService(o.kubernetesApiService, pod.Name, o.inspectionContainer()).ListProcesses(ctx)
+		if err != nil {
+			return err
+		}
+
+		o.settings.UserSpecifiedPid, err = o.pickProcess(process.FilterByExe(processes, o.settings.DetectedProcessExe),
+			"process running '"+o.settings.DetectedProcessExe+"'")
+		if err != nil {
+			return err
+		}
+	}
+
+	if pod.Spec.NodeName == o.settings.DetectedPodNodeName {
+		return nil
+	}
+
+	o.settings.DetectedPodNodeName = pod.Spec.NodeName
+
+	return o.selectDlvBinary(ctx)
+}
+
+// restoreLivenessProbe puts back the liveness probe removed for the session. A
+// session that didn't remove it puts back the one a previous session removed
+// from the workload owning the debugged pod, if any.
+func (o *DMM) restoreLivenessProbe(ctx context.Context) {
+	if o.probeGuardService != nil {
+		o.restoreWorkloadLivenessProbe(ctx, o.probeGuardService)
+		return
+	}
+
+	if o.settings.UserSpecifiedPodName != "" {
+		o.restoreLivenessProbeOfPod(ctx, o.resultingContext.Namespace, o.settings.UserSpecifiedPodName, o.settings.UserSpecifiedContainer)
+	}
+}
+
+// restoreLivenessProbeOfPod puts back the liveness probe a previous session
+// removed from the workload owning the pod, if any.
+func (o *DMM) restoreLivenessProbeOfPod(ctx context.Context, namespace string, podName string, containerName string) {
+	pod, err := o.clientset.CoreV1().Pods(namespace).Get(ctx, podName, v1.GetOptions{})
+	if err != nil {
+		log.WithError(err).Debugf("failed to get pod '%s', not restoring its liveness probe", podName)
+		return
+	}
+
+	probeGuardService := probe.NewProbeGuardService(o.clientset, namespace, containerName)
+	if err := probeGuardService.FindOwner(ctx, pod); err != nil {
+		log.WithError(err).Debugf("no workload owning pod '%s', not restoring its liveness probe", podName)
+		return
+	}
+
+	o.restoreWorkloadLivenessProbe(ctx, probeGuardService)
+}
+
+// restoreWorkloadLivenessProbe puts back the liveness probe of a workload once
+// no debug session remains on its pods: the rollout restoring the probe
+// replaces them, ending their sessions.
+func (o *DMM) restoreWorkloadLivenessProbe(ctx context.Context, probeGuardService *probe.ProbeGuardService) {
+	ownerKind, ownerName := probeGuardService.Owner()
+
+	disabled, err := probeGuardService.Disabled(ctx)
+	if err != nil {
+		log.WithError(err).Errorf("failed to restore the liveness probe of %s '%s', a manual restoration is required", ownerKind, ownerName)
+		return
+	}
+
+	if !disabled {
+		log.Debugf("no liveness probe to restore on %s '%s'", ownerKind, ownerName)
+		return
+	}
+
+	sessions, err := o.workloadSessions(ctx, probeGuardService)
+	if err != nil {
+		log.WithError(err).Warnf("failed to look for the debug sessions of %s '%s', not restoring its liveness probe", ownerKind, ownerName)
+		return
+	}
+
+	if len(sessions) > 0 {
+		log.Infof("not restoring the liveness probe of %s '%s' yet, debug sessions remain on its pods: '%s'",
+			ownerKind, ownerName, strings.Join(sessions, "', '"))
+		return
+	}
+
+	if err := probeGuardService.Restore(ctx); err != nil {
+		log.WithError(err).Errorf("failed to restore the liveness probe of %s '%s', a manual restoration is required", ownerKind, ownerName)
+	}
+}
+
+// workloadSessions returns the IDs of the debug sessions registered on the
+// pods of the workload owning the pod of the probe guard.
+func (o *DMM) workloadSessions(ctx context.Context, probeGuardService *probe.ProbeGuardService) ([]string, error) {
+	ownerKind, ownerName := probeGuardService.Owner()
+
+	sessions, err := o.sessionRegistry.List(ctx, probeGuardService.Namespace())
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, s := range sessions {
+		pod, err := o.clientset.CoreV1().Pods(s.Namespace).Get(ctx, s.Pod, v1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		owner := probe.NewProbeGuardService(o.clientset, s.Namespace, s.Container)
+		if err := owner.FindOwner(ctx, pod); errors.Is(err, probe.ErrNoWorkload) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if kind, name := owner.Owner(); kind == ownerKind && name == ownerName {
+			ids = append(ids, s.ID)
+		}
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,254 @@
+package cmd
+
+import (
+	"context"
+	"debug-me-maybe/kube"
+	"debug-me-maybe/pkg/service/debugger"
+	"debug-me-maybe/pkg/service/forwarder"
+	"debug-me-maybe/pkg/session"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+// NewCmdSession groups the commands managing the debug sessions, so that they
+// don't shadow the pods named list, attach or stop.
+func NewCmdSession(dmm *DMM) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "session",
+		Short: "Manage the debug sessions.",
+		Args:  cobra.NoArgs,
+	}
+
+	cmd.AddCommand(NewCmdList(dmm), NewCmdAttach(dmm), NewCmdStop(dmm))
+
+	return cmd
+}
+
+func NewCmdList(dmm *DMM) *cobra.Command {
+	var allNamespaces bool
+
+	cmd := &cobra.Command{
+		Use:          "list [-n namespace] [-A]",
+		Short:        "List the active debug sessions.",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := dmm.completeSession(); err != nil {
+				return err
+			}
+
+			namespace := dmm.resultingContext.Namespace
+			if allNamespaces {
+				namespace = corev1.NamespaceAll
+			}
+
+			return dmm.listSessions(c.Context(), namespace)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&allNamespaces, "all-namespaces", "A", false,
+		"if specified, list the debug sessions of all namespaces (optional)")
+
+	return cmd
+}
+
+func NewCmdAttach(dmm *DMM) *cobra.Command {
+	var localPort int
+
+	cmd := &cobra.Command{
+		Use:          "attach SESSION_ID [--local-port port]",
+		Short:        "Forward the port of the debugger of an existing debug session.",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := dmm.completeSession(); err != nil {
+				return err
+			}
+
+			return dmm.attachSession(c.Context(), args[0], localPort)
+		},
+	}
+
+	cmd.Flags().IntVar(&localPort, "local-port", 0,
+		"local port the debugger is forwarded to, a free port is picked when unset (optional)")
+
+	return cmd
+}
+
+func NewCmdStop(dmm *DMM) *cobra.Command {
+	var removeDlv bool
+
+	cmd := &cobra.Command{
+		Use:          "stop SESSION_ID",
+		Short:        "Kill the debugger of a debug session.",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := dmm.completeSession(); err != nil {
+				return err
+			}
+
+			return dmm.stopSession(c.Context(), args[0], removeDlv)
+		},
+	}
+
+	cmd.Flags().BoolVar(&removeDlv, "remove-dlv", false,
+		"if specified, the remote dlv binary is deleted once the debugger is killed (optional)")
+
+	return cmd
+}
+
+// completeSession prepares the clients and the session registry for the
+// session subcommands.
+func (o *DMM) completeSession() error {
+	o.settings.UserSpecifiedNamespace = viper.GetString("namespace")
+	o.settings.UserSpecifiedVerboseMode = viper.GetBool("verbose")
+	o.settings.UserSpecifiedKubeContext = viper.GetString("context")
+
+	o.completeVerboseMode()
+
+	if err := o.completeKubeConfig(); err != nil {
+		return err
+	}
+
+	var err error
+	o.sessionRegistry, err = session.NewRegistry(o.clientset, o.contextName())
+
+	return err
+}
+
+func (o *DMM) listSessions(ctx context.Context, namespace string) error {
+	sessions, err := o.sessionRegistry.List(ctx, namespace)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(o.streams.Out, 0, 8, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ID\tNAMESPACE\tPOD\tCONTAINER\tPID\tPORT\tREMOTE DLV\tAGE")
+
+	for _, s := range sessions {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n", s.ID, s.Namespace, s.Pod, s.Container,
+			s.Pid, s.DebuggerPort, s.RemoteDlvPath, duration.HumanDuration(time.Since(s.StartTime)))
+	}
+
+	return w.Flush()
+}
+
+func (o *DMM) attachSession(ctx context.Context, id string, localPort int) error {
+	s, err := o.sessionRegistry.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("attaching to session: '%s' [namespace: '%s', pod: '%s', port: '%d']", s.ID, s.Namespace, s.Pod, s.DebuggerPort)
+
+	kubernetesApiService := kube.NewKubernetesApiService(o.clientset, o.restConfig, s.Namespace, o.streams.ErrOut)
+
+	l := log.WithFields(log.Fields{
+		"session":      s.ID,
+		"port-forward": s.DebuggerPort,
+	})
+
+	forwarderService := forwarder.NewForwarderService(kubernetesApiService, s.Pod, localPort, s.DebuggerPort, l.Writer())
+
+	keepaliveCtx, stop := context.WithCancel(ctx)
+	go o.keepSessionAlive(keepaliveCtx, s)
+
+	// the session is detached again once the port-forward stops
+	defer func() {
+		stop()
+
+		cleanupCtx, cancel := cleanupContext()
+		defer cancel()
+
+		o.detachSession(cleanupCtx, s)
+	}()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- forwarderService.Run(ctx)
+	}()
+
+	select {
+	case localPort := <-forwarderService.Ready():
+		if err := o.printListenAddress(s, localPort); err != nil {
+			return err
+		}
+	case err := <-errCh:
+		return err
+	}
+
+	return <-errCh
+}
+
+func (o *DMM) stopSession(ctx context.Context, id string, removeDlv bool) error {
+	s, err := o.sessionRegistry.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return o.stopDebugSession(ctx, s, removeDlv)
+}
+
+// stopDebugSession kills the debugger of a session, forgets it and restores
+// the liveness probe it removed.
+func (o *DMM) stopDebugSession(ctx context.Context, s session.Session, removeDlv bool) error {
+	log.Infof("stopping session: '%s' [namespace: '%s', pod: '%s']", s.ID, s.Namespace, s.Pod)
+
+	settings := *o.settings
+	settings.UserSpecifiedPodName = s.Pod
+	settings.UserSpecifiedContainer = s.Container
+	settings.DetectedDebuggerContainer = s.DebuggerContainer
+	settings.DetectedRemoteDlvPath = s.RemoteDlvPath
+	settings.DetectedCachedDlvPath = s.DlvPath
+	settings.DetectedSessionId = s.ID
+	settings.UserSpecifiedPid = s.Pid
+	settings.UserSpecifiedDebuggerPort = s.DebuggerPort
+	settings.UserSpecifiedRemoveDlv = removeDlv
+	settings.DetectedDlvShared = removeDlv && o.dlvShared(ctx, s)
+
+	kubernetesApiService := kube.NewKubernetesApiService(o.clientset, o.restConfig, s.Namespace, o.streams.ErrOut)
+
+	err := debugger.NewUploadDlvRemoteDebuggingService(&settings, kubernetesApiService).Cleanup(ctx)
+	if errors.Is(err, debugger.ErrNotRunning) {
+		log.WithError(err).Infof("the debugger of session: '%s' already exited", s.ID)
+	} else if err != nil {
+		return errors.Wrapf(err, "failed to stop the debugger of session: '%s'", s.ID)
+	}
+
+	if err := o.sessionRegistry.Unregister(ctx, s); err != nil {
+		return err
+	}
+
+	// the probe is only restored once no session remains on the workload
+	o.restoreLivenessProbeOfPod(ctx, s.Namespace, s.Pod, s.Container)
+
+	return nil
+}
+
+// dlvShared tells whether another session registered on the pod runs the dlv
+// binary of a session, which is then kept when the session is stopped. It is
+// assumed shared when the sessions can't be listed.
+func (o *DMM) dlvShared(ctx context.Context, s session.Session) bool {
+	sessions, err := o.sessionRegistry.List(ctx, s.Namespace)
+	if err != nil {
+		log.WithError(err).Warnf("failed to list debug sessions, keeping the dlv binary: '%s'", s.DlvPath)
+		return true
+	}
+
+	for _, other := range sessions {
+		if s.SharesDlv(other) {
+			return true
+		}
+	}
+
+	return false
+}
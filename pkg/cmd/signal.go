@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// cleanupTimeout bounds the cleanup of the debugger once the session ends, so
+// that an unreachable pod doesn't keep dmm from exiting.
+const cleanupTimeout = time.Minute
+
+// forceExitCode is the exit code on a second interrupt, as a shell reports a
+// process killed by SIGINT.
+const forceExitCode = 130
+
+// SignalContext returns a context done on SIGINT, SIGTERM or SIGHUP, for the
+// commands to clean up what they created before exiting. A second signal exits
+// right away, skipping the cleanup.
+func SignalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Warnf("received %s, stopping, interrupt again to exit right away", sig)
+			cancel()
+		case <-ctx.Done():
+			return
+		}
+
+		sig := <-signals
+		log.Errorf("received %s again, exiting without cleaning up: the debugger may keep running, see 'dmm session list' and 'dmm gc'", sig)
+		os.Exit(forceExitCode)
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+// cleanupContext returns the context of a cleanup, bounded by cleanupTimeout
+// and not done on interrupt, the command context being done by then.
+func cleanupContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), cleanupTimeout)
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"debug-me-maybe/kube"
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// NewCmdStagerServe serves the files of a directory over HTTP. It is run by the
+// builtin stager in the stager pod, from the plugin binary uploaded there.
+func NewCmdStagerServe() *cobra.Command {
+	var directory string
+	var port int
+
+	cmd := &cobra.Command{
+		Use:          kube.BuiltinStagerCommand,
+		Short:        "Serve the files of a directory over HTTP, run in the stager pod.",
+		Args:         cobra.NoArgs,
+		Hidden:       true,
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			log.Infof("serving: '%s' on port: '%d'", directory, port)
+
+			return http.ListenAndServe(fmt.Sprintf(":%d", port), http.FileServer(http.Dir(directory)))
+		},
+	}
+
+	cmd.Flags().StringVar(&directory, "directory", "/tmp", "directory to serve")
+	cmd.Flags().IntVar(&port, "port", 8000, "port to listen on")
+
+	return cmd
+}
@@ -0,0 +1,326 @@
+package cmd
+
+import (
+	"context"
+	"debug-me-maybe/kube"
+	"debug-me-maybe/pkg/config"
+	"debug-me-maybe/pkg/service/debugger"
+	"debug-me-maybe/pkg/service/process"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	podKind         = "pod"
+	deploymentKind  = "deployment"
+	statefulSetKind = "statefulset"
+	daemonSetKind   = "daemonset"
+	jobKind         = "job"
+)
+
+var workloadKindAliases = map[string]string{
+	"po":           podKind,
+	"pod":          podKind,
+	"pods":         podKind,
+	"deploy":       deploymentKind,
+	"deployment":   deploymentKind,
+	"deployments":  deploymentKind,
+	"sts":          statefulSetKind,
+	"statefulset":  statefulSetKind,
+	"statefulsets": statefulSetKind,
+	"ds":           daemonSetKind,
+	"daemonset":    daemonSetKind,
+	"daemonsets":   daemonSetKind,
+	"job":          jobKind,
+	"jobs":         jobKind,
+}
+
+// parseTarget splits a 'TYPE/NAME' argument into a normalized kind and a name.
+// A bare name is treated as a pod name.
+func parseTarget(target string) (string, string, error) {
+	kind, name, found := strings.Cut(target, "/")
+	if !found {
+		return podKind, target, nil
+	}
+
+	normalizedKind, ok := workloadKindAliases[strings.ToLower(kind)]
+	if !ok {
+		return "", "", errors.Errorf("unsupported target type: '%s', expected one of pod, deploy, sts, ds or job", kind)
+	}
+
+	if name == "" {
+		return "", "", errors.Errorf("target name is empty in: '%s'", target)
+	}
+
+	if strings.Contains(name, "/") {
+		return "", "", errors.Errorf("invalid target name: '%s' in: '%s', expected TYPE/NAME", name, target)
+	}
+
+	return normalizedKind, name, nil
+}
+
+// workloadSelector returns the pod selector of a workload of the namespace,
+// and the UIDs of the controllers owning its pods: the workload itself, or the
+// replicasets of a deployment. The selector of a workload may also match pods
+// it doesn't own, which are told apart by their owner reference.
+func (o *DMM) workloadSelector(ctx context.Context, kind string, name string) (labels.Selector, map[types.UID]bool, error) {
+	namespace := o.resultingContext.Namespace
+
+	var selector *v1.LabelSelector
+	owners := map[types.UID]bool{}
+
+	switch kind {
+	case deploymentKind:
+		deployment, err := o.clientset.AppsV1().Deployments(namespace).Get(ctx, name, v1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		selector = deployment.Spec.Selector
+
+		replicaSets, err := o.clientset.AppsV1().ReplicaSets(namespace).List(ctx, v1.ListOptions{
+			LabelSelector: v1.FormatLabelSelector(selector),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, replicaSet := range replicaSets.Items {
+			if v1.IsControlledBy(&replicaSet, deployment) {
+				owners[replicaSet.UID] = true
+			}
+		}
+	case statefulSetKind:
+		statefulSet, err := o.clientset.AppsV1().StatefulSets(namespace).Get(ctx, name, v1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		selector = statefulSet.Spec.Selector
+		owners[statefulSet.UID] = true
+	case daemonSetKind:
+		daemonSet, err := o.clientset.AppsV1().DaemonSets(namespace).Get(ctx, name, v1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		selector = daemonSet.Spec.Selector
+		owners[daemonSet.UID] = true
+	case jobKind:
+		job, err := o.clientset.BatchV1().Jobs(namespace).Get(ctx, name, v1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		selector = job.Spec.Selector
+		owners[job.UID] = true
+	default:
+		return nil, nil, errors.Errorf("unsupported workload kind: '%s'", kind)
+	}
+
+	if selector == nil {
+		return nil, nil, errors.Errorf("%s '%s' has no pod selector", kind, name)
+	}
+
+	podSelector, err := v1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return podSelector, owners, nil
+}
+
+// resolveWorkloadPod picks the pod to debug among the pods owned by the user
+// specified workload.
+func (o *DMM) resolveWorkloadPod(ctx context.Context) (string, error) {
+	selector, owners, err := o.workloadSelector(ctx, o.settings.UserSpecifiedWorkloadKind, o.settings.UserSpecifiedWorkloadName)
+	if err != nil {
+		return "", err
+	}
+
+	return o.selectPod(ctx, selector, owners, fmt.Sprintf("%s '%s'",
+		o.settings.UserSpecifiedWorkloadKind, o.settings.UserSpecifiedWorkloadName))
+}
+
+// resolveSelectorPod picks the pod to debug among the pods matching the user
+// specified label selector.
+func (o *DMM) resolveSelectorPod(ctx context.Context) (string, error) {
+	selector, err := labels.Parse(o.settings.UserSpecifiedSelector)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid label selector: '%s'", o.settings.UserSpecifiedSelector)
+	}
+
+	return o.selectPod(ctx, selector, nil, fmt.Sprintf("selector '%s'", selector.String()))
+}
+
+// selectPod picks the pod to debug among the pods matching the selector, only
+// among the ones controlled by owners when it isn't nil.
+func (o *DMM) selectPod(ctx context.Context, selector labels.Selector, owners map[types.UID]bool, description string) (string, error) {
+	log.Debugf("resolving pods of %s with selector: '%s'", description, selector.String())
+
+	pods, err := o.clientset.CoreV1().Pods(o.resultingContext.Namespace).List(ctx, v1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var candidates []string
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+
+		if owners != nil {
+			if controller := v1.GetControllerOf(&pod); controller == nil || !owners[controller.UID] {
+				log.Debugf("skipping pod '%s': not owned by %s", pod.Name, description)
+				continue
+			}
+		}
+
+		if !o.settings.UserSpecifiedAllPods && !isPodReady(&pod) {
+			log.Debugf("skipping pod '%s': not ready", pod.Name)
+			continue
+		}
+
+		candidates = append(candidates, pod.Name)
+	}
+
+	if len(candidates) == 0 {
+		return "", errors.Errorf("no eligible pod found for %s", description)
+	}
+
+	sort.Strings(candidates)
+
+	index := o.settings.UserSpecifiedPodIndex
+	if index < 0 {
+		index, err = o.pick("pod", candidates)
+		if err != nil {
+			return "", err
+		}
+	} else if index >= len(candidates) {
+		return "", errors.Errorf("pod index %d is out of range, %s has %d eligible pod(s)",
+			index, description, len(candidates))
+	}
+
+	log.Infof("selected pod '%s' (%d/%d) of %s", candidates[index], index+1, len(candidates), description)
+
+	return candidates[index], nil
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+// ensureDebuggerContainer adds the debugger ephemeral container of the
+// ephemeral upload method to the pod, before the processes are looked up: it
+// shares the process namespace of the target container, which may have no
+// shell.
+func (o *DMM) ensureDebuggerContainer(ctx context.Context) error {
+	if o.settings.UserSpecifiedUploadMethod != config.EPHEMERAL || o.settings.UserSpecifiedForceKill || o.doctorMode {
+		return nil
+	}
+
+	var err error
+	o.settings.DetectedDebuggerContainer, err = o.kubernetesApiService.EnsureEphemeralContainer(ctx, o.settings.UserSpecifiedPodName,
+		o.settings.UserSpecifiedContainer, o.settings.UserSpecifiedImage)
+
+	return err
+}
+
+// inspectionContainer returns the container the processes of the target
+// container are inspected from: the debugger ephemeral container when there
+// is one, the target container otherwise.
+func (o *DMM) inspectionContainer() string {
+	if o.settings.DetectedDebuggerContainer != "" {
+		return o.settings.DetectedDebuggerContainer
+	}
+
+	return o.settings.UserSpecifiedContainer
+}
+
+// resolvePid finds the pid to debug from the user specified process name or
+// executable path. When neither nor a pid is specified, the Go processes of the
+// container are offered as candidates, falling back to pid 1.
+func (o *DMM) resolvePid(ctx context.Context, kubernetesApiService kube.KubernetesApiService) (int, error) {
+	if o.settings.UserSpecifiedPid != 0 {
+		return o.settings.UserSpecifiedPid, nil
+	}
+
+	processService := process.NewProcessService(kubernetesApiService,
+		o.settings.UserSpecifiedPodName, o.inspectionContainer())
+
+	processes, err := processService.ListProcesses(ctx)
+
+	var candidates []process.Process
+	var description string
+
+	switch {
+	case o.settings.UserSpecifiedProcessName != "":
+		if err != nil {
+			return 0, err
+		}
+		description = fmt.Sprintf("process named '%s'", o.settings.UserSpecifiedProcessName)
+		candidates = process.FilterByName(processes, o.settings.UserSpecifiedProcessName)
+	case o.settings.UserSpecifiedExePath != "":
+		if err != nil {
+			return 0, err
+		}
+		description = fmt.Sprintf("process running '%s'", o.settings.UserSpecifiedExePath)
+		candidates = process.FilterByExe(processes, o.settings.UserSpecifiedExePath)
+	default:
+		if err != nil {
+			log.WithError(err).Warn("failed to look for Go processes, defaulting to pid 1")
+			return 1, nil
+		}
+
+		candidates = process.FilterGo(processes, o.settings.DetectedRemoteDlvPath,
+			debugger.CachedDlvPattern(o.settings.DetectedRemoteDlvPath))
+		if len(candidates) == 0 {
+			log.Warn("no Go process found on container, defaulting to pid 1")
+			return 1, nil
+		}
+		description = "Go process"
+	}
+
+	return o.pickProcess(candidates, description)
+}
+
+// pickProcess returns the pid of the process chosen among candidates, and
+// records its executable for the process to be found again in a pod replacing
+// the debugged one.
+func (o *DMM) pickProcess(candidates []process.Process, description string) (int, error) {
+	if len(candidates) == 0 {
+		return 0, errors.Errorf("no %s found on container: '%s'", description, o.settings.UserSpecifiedContainer)
+	}
+
+	var options []string
+	for _, candidate := range candidates {
+		options = append(options, candidate.String())
+	}
+
+	index, err := o.pick(description, options)
+	if err != nil {
+		return 0, err
+	}
+
+	log.Infof("selected %s: '%s'", description, options[index])
+
+	o.settings.DetectedProcessExe = candidates[index].Exe
+
+	return candidates[index].Pid, nil
+}
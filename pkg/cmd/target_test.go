@@ -0,0 +1,41 @@
+package cmd
+
+import "testing"
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		target string
+		kind   string
+		name   string
+		valid  bool
+	}{
+		{target: "operator-7d9f-x2x4z", kind: podKind, name: "operator-7d9f-x2x4z", valid: true},
+		{target: "pod/operator-7d9f-x2x4z", kind: podKind, name: "operator-7d9f-x2x4z", valid: true},
+		{target: "po/operator-7d9f-x2x4z", kind: podKind, name: "operator-7d9f-x2x4z", valid: true},
+		{target: "deploy/operator", kind: deploymentKind, name: "operator", valid: true},
+		{target: "Deployments/operator", kind: deploymentKind, name: "operator", valid: true},
+		{target: "sts/db", kind: statefulSetKind, name: "db", valid: true},
+		{target: "statefulset/db", kind: statefulSetKind, name: "db", valid: true},
+		{target: "ds/agent", kind: daemonSetKind, name: "agent", valid: true},
+		{target: "daemonsets/agent", kind: daemonSetKind, name: "agent", valid: true},
+		{target: "job/migrate", kind: jobKind, name: "migrate", valid: true},
+		{target: "rs/operator-7d9f", valid: false},
+		{target: "deploy/", valid: false},
+		{target: "/operator", valid: false},
+		{target: "deploy/a/b", valid: false},
+		{target: "pod/operator/", valid: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.target, func(t *testing.T) {
+			kind, name, err := parseTarget(test.target)
+			if (err == nil) != test.valid {
+				t.Fatalf("parseTarget(%q) error = %v, want valid: %t", test.target, err, test.valid)
+			}
+
+			if kind != test.kind || name != test.name {
+				t.Errorf("parseTarget(%q) = %q, %q, want %q, %q", test.target, kind, name, test.kind, test.name)
+			}
+		})
+	}
+}
@@ -1,32 +1,74 @@
 package config
 
 import (
+	"time"
+
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 )
 
 type UploadMethod string
 
 const (
-	DIRECT UploadMethod = "direct"
-	STAGER UploadMethod = "stager"
+	DIRECT    UploadMethod = "direct"
+	STAGER    UploadMethod = "stager"
+	EPHEMERAL UploadMethod = "ephemeral"
+	CHUNKED   UploadMethod = "chunked"
 )
 
 type DMMSettings struct {
-	UserSpecifiedPodName       string
-	UserSpecifiedContainer     string
-	UserSpecifiedNamespace     string
-	UserSpecifiedVerboseMode   bool
-	UserSpecifiedImage         string
-	UserSpecifiedPid           int
-	DetectedPodNodeName        string
-	DetectedContainerId        string
-	DetectedContainerRuntime   string
-	UserSpecifiedKubeContext   string
-	UserSpecifiedLocalDlvPath  string
-	UserSpecifiedRemoteDlvPath string
-	UserSpecifiedDebuggerPort  int
-	UserSpecifiedForceKill     bool
-	UserSpecifiedUploadMethod  UploadMethod
+	UserSpecifiedPodName           string
+	UserSpecifiedWorkloadKind      string
+	UserSpecifiedWorkloadName      string
+	UserSpecifiedPodIndex          int
+	UserSpecifiedAllPods           bool
+	UserSpecifiedSelector          string
+	UserSpecifiedFirst             bool
+	UserSpecifiedContainer         string
+	UserSpecifiedNamespace         string
+	UserSpecifiedVerboseMode       bool
+	UserSpecifiedImage             string
+	UserSpecifiedPid               int
+	UserSpecifiedProcessName       string
+	UserSpecifiedExePath           string
+	DetectedProcessExe             string
+	DetectedPodNodeName            string
+	DetectedContainerId            string
+	DetectedContainerRuntime       string
+	DetectedArchitecture           string
+	DetectedGoVersion              string
+	DetectedDelveVersion           string
+	UserSpecifiedKubeContext       string
+	UserSpecifiedLocalDlvPath      string
+	UserSpecifiedRemoteDlvDirs     []string
+	UserSpecifiedRemoteDlvPath     string
+	DetectedRemoteDlvPath          string
+	DetectedCachedDlvPath          string
+	DetectedDlvShared              bool
+	UserSpecifiedDebuggerPort      int
+	UserSpecifiedLocalPort         int
+	UserSpecifiedForceKill         bool
+	UserSpecifiedRemoveDlv         bool
+	UserSpecifiedKeepLease         bool
+	UserSpecifiedDetach            bool
+	UserSpecifiedTtl               time.Duration
+	UserSpecifiedIdleTimeout       time.Duration
+	UserSpecifiedLeaseName         string
+	UserSpecifiedDisableProbes     bool
+	UserSpecifiedSkipPreflight     bool
+	UserSpecifiedUploadMethod      UploadMethod
+	UserSpecifiedStagerImage       string
+	UserSpecifiedStagerSecrets     []string
+	UserSpecifiedStagerNodes       []string
+	UserSpecifiedStagerTolerations []string
+	UserSpecifiedStagerCpu         string
+	UserSpecifiedStagerMemory      string
+	UserSpecifiedStagerUser        int64
+	UserSpecifiedStagerTimeout     time.Duration
+	UserSpecifiedStagerPolicy      bool
+	UserSpecifiedBuiltinStager     bool
+	DetectedDebuggerContainer      string
+	DetectedSessionId              string
+	DetectedOwner                  string
 }
 
 func NewDMMSettings(streams genericclioptions.IOStreams) *DMMSettings {
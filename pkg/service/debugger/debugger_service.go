@@ -1,17 +1,29 @@
 package debugger
 
 import (
+	"context"
 	"io"
+
+	"github.com/pkg/errors"
 )
 
+// ErrLogStreamLost is returned by Start when the connection streaming the
+// remote debugger output is lost once the debugger was launched, the debugger
+// then keeps running.
+var ErrLogStreamLost = errors.New("lost the remote debugger output stream")
+
+// ErrNotRunning is returned by Cleanup when the debugger of the session isn't
+// running anymore, e.g. once stopped by its watchdog.
+var ErrNotRunning = errors.New("the remote debugger isn't running")
+
 type DebuggerService interface {
 	// Perform all actions required for starting the remote sniffing
-	Setup() error
+	Setup(ctx context.Context) error
 
 	// Rollback actions performed during the Setup phase
-	Cleanup() error
+	Cleanup(ctx context.Context) error
 
 	// Start remote sniffing
-	// write remote capture output to the given io writer.
-	Start(stdOut io.Writer) error
+	// write remote capture output to the given io writer, until ctx is done.
+	Start(ctx context.Context, stdOut io.Writer) error
 }
@@ -1,14 +1,19 @@
 package debugger
 
 import (
+	"context"
 	"debug-me-maybe/kube"
 	"debug-me-maybe/pkg/config"
 	"fmt"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"io"
+	"math"
+	"path"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 type DlvDebuggerService struct {
@@ -20,77 +25,487 @@ func NewUploadDlvRemoteDebuggingService(options *config.DMMSettings, service kub
 	return &DlvDebuggerService{settings: options, kubernetesApiService: service}
 }
 
-func (u *DlvDebuggerService) Setup() error {
-	log.Infof("uploading dlv binary from: '%s' to: '%s'",
-		u.settings.UserSpecifiedLocalDlvPath, u.settings.UserSpecifiedRemoteDlvPath)
+// uploadAttempts is the number of times dlv is uploaded before giving up when
+// the uploaded file doesn't match the local one.
+const uploadAttempts = 2
 
+// CachedDlvPath returns where a dlv binary is uploaded: in a directory named
+// after its SHA-256 next to the remote dlv path, e.g. /tmp/dmm/<sha>/dlv for
+// /tmp/dlv, so that a stale or truncated binary is never mistaken for it.
+func CachedDlvPath(remoteDlvPath string, sha string) string {
+	return path.Join(path.Dir(remoteDlvPath), "dmm", sha, path.Base(remoteDlvPath))
+}
+
+// CachedDlvPattern matches the dlv binaries uploaded for a remote dlv path,
+// whatever their SHA-256.
+func CachedDlvPattern(remoteDlvPath string) string {
+	return CachedDlvPath(remoteDlvPath, "*")
+}
+
+func (u *DlvDebuggerService) Setup(ctx context.Context) error {
 	var err error
+	if u.settings.UserSpecifiedUploadMethod == config.EPHEMERAL {
+		log.Info("uploading using the EPHEMERAL method (will fail if ephemeral containers are not supported by the cluster)")
+		u.settings.DetectedDebuggerContainer, err = u.kubernetesApiService.EnsureEphemeralContainer(ctx, u.settings.UserSpecifiedPodName,
+			u.settings.UserSpecifiedContainer, u.settings.UserSpecifiedImage)
+		if err != nil {
+			return err
+		}
+
+		if u.imageShipsDlv(ctx) {
+			log.Infof("using the dlv binary shipped by image: '%s' at: '%s'", u.settings.UserSpecifiedImage, u.settings.DetectedRemoteDlvPath)
+			u.settings.DetectedCachedDlvPath = u.settings.DetectedRemoteDlvPath
+			return nil
+		}
+	}
+
+	remotePath := u.settings.DetectedCachedDlvPath
+
+	err = u.kubernetesApiService.VerifyFile(ctx, u.settings.UserSpecifiedLocalDlvPath, remotePath, u.settings.UserSpecifiedPodName, u.debuggerContainer())
+	if err == nil {
+		log.Infof("dlv binary already uploaded to: '%s'", remotePath)
+		return nil
+	}
+
+	log.WithError(err).Debugf("dlv binary needs to be uploaded")
+
+	for attempt := 1; attempt <= uploadAttempts; attempt++ {
+		if err := u.upload(ctx, remotePath); err != nil {
+			log.WithError(err).Errorf("failed uploading dlv binary to container, please verify the remote container has the tools required by the upload method")
+			return err
+		}
+
+		err = u.kubernetesApiService.VerifyFile(ctx, u.settings.UserSpecifiedLocalDlvPath, remotePath, u.settings.UserSpecifiedPodName, u.debuggerContainer())
+		if err == nil {
+			log.Info("dlv uploaded successfully")
+			return nil
+		}
+
+		// an unverified binary could be a truncated one, dlv also needs the
+		// shell verifying it to be launched
+		if !errors.Is(err, kube.ErrFileMismatch) {
+			return errors.Wrap(err, "failed to verify the uploaded dlv binary")
+		}
+
+		log.WithError(err).Warnf("uploaded dlv binary is corrupted (attempt %d/%d)", attempt, uploadAttempts)
+	}
+
+	return errors.Wrapf(err, "failed uploading dlv binary after %d attempts", uploadAttempts)
+}
+
+// imageShipsDlv tells whether the image of the debugger ephemeral container
+// ships an executable dlv at the remote dlv path, which is then used instead
+// of uploading one.
+func (u *DlvDebuggerService) imageShipsDlv(ctx context.Context) bool {
+	command := []string{"/bin/sh", "-c", `[ -x "$0" ]`, u.settings.DetectedRemoteDlvPath}
+	exitCode, err := u.kubernetesApiService.ExecuteCommand(ctx, u.settings.UserSpecifiedPodName, u.debuggerContainer(), command, nil)
+	if err != nil {
+		log.WithError(err).Debugf("failed to look for dlv at: '%s'", u.settings.DetectedRemoteDlvPath)
+		return false
+	}
+
+	return exitCode == 0
+}
+
+// StagerConfig returns the configuration of the stager pod of the stager
+// upload method.
+func StagerConfig(settings *config.DMMSettings) (kube.StagerConfig, error) {
+	stager, err := kube.ParseStagerConfig(settings.UserSpecifiedStagerImage, settings.UserSpecifiedStagerSecrets,
+		settings.UserSpecifiedStagerNodes, settings.UserSpecifiedStagerTolerations, settings.UserSpecifiedStagerCpu,
+		settings.UserSpecifiedStagerMemory, settings.UserSpecifiedStagerUser, settings.UserSpecifiedStagerTimeout,
+		settings.UserSpecifiedBuiltinStager)
+	if err != nil {
+		return kube.StagerConfig{}, err
+	}
+
+	stager.NetworkPolicy = settings.UserSpecifiedStagerPolicy
+	stager.Session = settings.DetectedSessionId
+	stager.Owner = settings.DetectedOwner
+
+	return stager, nil
+}
+
+// upload replaces the remote dlv binary with the local one, using the upload
+// method.
+func (u *DlvDebuggerService) upload(ctx context.Context, remotePath string) error {
+	log.Infof("uploading dlv binary from: '%s' to: '%s'", u.settings.UserSpecifiedLocalDlvPath, remotePath)
+
+	// the upload methods keep an existing file, remove what's left of a
+	// previous attempt first
+	command := []string{"/bin/sh", "-c", `rm -f "$0" && mkdir -p "$(dirname "$0")"`, remotePath}
+	exitCode, err := u.kubernetesApiService.ExecuteCommand(ctx, u.settings.UserSpecifiedPodName, u.debuggerContainer(), command, nil)
+	if err != nil || exitCode != 0 {
+		return errors.Errorf("failed to prepare: '%s' on container: '%s', exit code: '%d'", remotePath, u.debuggerContainer(), exitCode)
+	}
+
 	switch u.settings.UserSpecifiedUploadMethod {
 	case config.DIRECT:
 		log.Info("uploading using the DIRECT method (will fail it 'tar' is not present on the pod)")
-		err = u.kubernetesApiService.UploadFileTar(u.settings.UserSpecifiedLocalDlvPath,
-			u.settings.UserSpecifiedRemoteDlvPath, u.settings.UserSpecifiedPodName, u.settings.UserSpecifiedContainer)
+		return u.kubernetesApiService.UploadFileTar(ctx, u.settings.UserSpecifiedLocalDlvPath,
+			remotePath, u.settings.UserSpecifiedPodName, u.settings.UserSpecifiedContainer)
 	case config.STAGER:
 		log.Info("uploading using the STAGER method (will fail it 'curl' is not present on the pod)")
-		err = u.kubernetesApiService.UploadThroughCurl(u.settings.UserSpecifiedLocalDlvPath,
-			u.settings.UserSpecifiedRemoteDlvPath, u.settings.UserSpecifiedPodName, u.settings.UserSpecifiedContainer)
+		stager, err := StagerConfig(u.settings)
+		if err != nil {
+			return err
+		}
+
+		return u.kubernetesApiService.UploadThroughCurl(ctx, u.settings.UserSpecifiedLocalDlvPath,
+			remotePath, u.settings.UserSpecifiedPodName, u.settings.UserSpecifiedContainer, stager)
+	case config.CHUNKED:
+		log.Info("uploading using the CHUNKED method (will fail if neither 'cat' nor 'base64' is present on the pod)")
+		return u.kubernetesApiService.UploadChunked(ctx, u.settings.UserSpecifiedLocalDlvPath,
+			remotePath, u.settings.UserSpecifiedPodName, u.settings.UserSpecifiedContainer)
+	case config.EPHEMERAL:
+		return u.kubernetesApiService.UploadFileTar(ctx, u.settings.UserSpecifiedLocalDlvPath,
+			remotePath, u.settings.UserSpecifiedPodName, u.settings.DetectedDebuggerContainer)
 	default:
-		err = errors.Errorf("invalid upload method: %s", u.settings.UserSpecifiedUploadMethod)
+		return errors.Errorf("invalid upload method: %s", u.settings.UserSpecifiedUploadMethod)
 	}
+}
+
+// debuggerContainer returns the container dlv runs in: the debugger ephemeral
+// container when there is one, the target container otherwise.
+func (u *DlvDebuggerService) debuggerContainer() string {
+	if u.settings.DetectedDebuggerContainer != "" {
+		return u.settings.DetectedDebuggerContainer
+	}
+
+	return u.settings.UserSpecifiedContainer
+}
+
+// SessionPath returns the path the log and pid files of the dlv of a session
+// are named after, next to the remote dlv path, e.g. /tmp/dlv.<session> for
+// /tmp/dlv, so that concurrent sessions don't share them. Sessions without an
+// ID use the remote dlv path itself.
+func SessionPath(remoteDlvPath string, session string) string {
+	if session == "" {
+		return remoteDlvPath
+	}
+
+	return remoteDlvPath + "." + session
+}
+
+// findDlvPidScript prints the pid recorded in the pidfile of a session ($0)
+// when it is a running dlv, either the remote dlv path ($1) or a binary
+// uploaded for it (matching $2). A zombie has exited, a recycled pid runs
+// something else.
+const findDlvPidScript = `[ -f "$0.pid" ] || exit 0
+pid=$(cat "$0.pid")
+read -r stat 2>/dev/null < "/proc/$pid/stat" || exit 0
+stat=${stat##*) }
+[ "${stat%% *}" = Z ] && exit 0
+argv0=$(tr '\0' '\n' < "/proc/$pid/cmdline" 2>/dev/null | head -n 1)
+case "$argv0" in "$1"|$2) echo $pid ;; esac
+exit 0`
+
+// scanDlvPidsScript prints the pids of every running dlv, either the remote
+// dlv path ($0) or a binary uploaded for it (matching $1), for the dlv
+// instances no session records, e.g. left by a dmm that crashed.
+const scanDlvPidsScript = `for p in /proc/[0-9]*; do
+  pid=${p#/proc/}
+  read -r stat 2>/dev/null < "$p/stat" || continue
+  stat=${stat##*) }
+  [ "${stat%% *}" = Z ] && continue
+  argv0=$(tr '\0' '\n' < "$p/cmdline" 2>/dev/null | head -n 1)
+  case "$argv0" in "$0"|$1) echo $pid ;; esac
+done
+exit 0`
 
+// killScript sends SIGTERM to a pid ($1), waits for it to exit for up to $0
+// seconds, then sends it SIGKILL and prints it. A zombie has exited.
+const killScript = `alive() {
+  read -r stat 2>/dev/null < "/proc/$1/stat" || return 1
+  stat=${stat##*) }
+  [ "${stat%% *}" != Z ]
+}
+kill -TERM $1 2>/dev/null
+i=0
+while alive $1; do
+  if [ $i -ge $0 ]; then
+    kill -KILL $1 2>/dev/null
+    echo $1
+    exit 0
+  fi
+  sleep 1
+  i=$((i+1))
+done`
+
+const killTimeout = 10 * time.Second
+
+func (u *DlvDebuggerService) Cleanup(ctx context.Context) error {
+	log.Info("killing dlv process on remote container")
+
+	pids, err := u.findDlvPids(ctx)
 	if err != nil {
-		log.WithError(err).Errorf("failed uploading dlv binary to container, please verify the remote container has tar installed")
 		return err
 	}
 
-	log.Info("dlv uploaded successfully")
+	// the debugged process and the uploaded files are taken care of anyway
+	var notRunning error
+	if len(pids) == 0 && u.settings.DetectedSessionId == "" {
+		notRunning = errors.Wrapf(ErrNotRunning, "no dlv process running '%s' found", u.settings.DetectedRemoteDlvPath)
+	} else if len(pids) == 0 {
+		notRunning = errors.Wrapf(ErrNotRunning, "no dlv process recorded in '%s.pid' running", u.sessionPath())
+	}
 
-	return nil
+	for _, pid := range pids {
+		if err := u.kill(ctx, pid); err != nil {
+			return err
+		}
+	}
+
+	if err := u.verifyTargetResumed(ctx); err != nil {
+		return err
+	}
+
+	if u.settings.UserSpecifiedRemoveDlv {
+		u.removeDlv(ctx)
+	}
+
+	return notRunning
 }
 
-func (u *DlvDebuggerService) Cleanup() error {
-	log.Info("killing dlv process on remote container")
+func (u *DlvDebuggerService) kill(ctx context.Context, pid string) error {
+	log.Infof("found dlv process: '%s'", pid)
+
+	command := []string{"/bin/sh", "-c", killScript, strconv.Itoa(int(killTimeout.Seconds())), pid}
+
+	killOutput := new(kube.Writer)
+	exitCode, err := u.kubernetesApiService.ExecuteCommand(ctx, u.settings.UserSpecifiedPodName, u.debuggerContainer(), command, killOutput)
+	if err != nil || exitCode != 0 {
+		return errors.Errorf("failed to kill dlv pid '%s' with exit code: '%d'", pid, exitCode)
+	}
 
-	commandPidof := []string{
-		"pidof",
-		u.settings.UserSpecifiedRemoteDlvPath,
+	if killed := strings.TrimSpace(killOutput.Output); killed != "" {
+		log.Warnf("dlv pid '%s' didn't exit within %s after SIGTERM and was sent SIGKILL", killed, killTimeout)
 	}
 
-	pidofOutput := new(kube.Writer)
-	exitCode, err := u.kubernetesApiService.ExecuteCommand(u.settings.UserSpecifiedPodName, u.settings.UserSpecifiedContainer, commandPidof, pidofOutput)
+	log.Infof("remote dlv process killed")
 
+	return nil
+}
+
+// findDlvPids returns the pid of the dlv of the session, none when it isn't
+// running. Other dlv instances, e.g. of other sessions, are left alone. Without
+// a session, every dlv running the remote dlv path is returned.
+func (u *DlvDebuggerService) findDlvPids(ctx context.Context) ([]string, error) {
+	command := []string{"/bin/sh", "-c", findDlvPidScript, u.sessionPath(), u.settings.DetectedRemoteDlvPath,
+		CachedDlvPattern(u.settings.DetectedRemoteDlvPath)}
+	if u.settings.DetectedSessionId == "" {
+		command = []string{"/bin/sh", "-c", scanDlvPidsScript, u.settings.DetectedRemoteDlvPath,
+			CachedDlvPattern(u.settings.DetectedRemoteDlvPath)}
+	}
+
+	output := new(kube.Writer)
+	exitCode, err := u.kubernetesApiService.ExecuteCommand(ctx, u.settings.UserSpecifiedPodName, u.debuggerContainer(), command, output)
 	if err != nil || exitCode != 0 {
-		return errors.Errorf("failed to execute 'pidof %s' with exit code: '%d', perhaps the debugger is already closed?", u.settings.UserSpecifiedRemoteDlvPath, exitCode)
+		return nil, errors.Errorf("failed to look for the dlv processes of: '%s' with exit code: '%d'", u.sessionPath(), exitCode)
 	}
 
-	dlvPid, err := strconv.ParseInt(strings.TrimSpace(pidofOutput.Output), 10, 64)
+	pids := strings.Fields(output.Output)
+	for _, pid := range pids {
+		if _, err := strconv.Atoi(pid); err != nil {
+			return nil, errors.Errorf("failed to convert the retrieved pid of dlv to an integer: %s", err)
+		}
+	}
 
+	return pids, nil
+}
+
+// sessionPath returns the path the log and pid files of the session are named
+// after.
+func (u *DlvDebuggerService) sessionPath() string {
+	return SessionPath(u.settings.DetectedRemoteDlvPath, u.settings.DetectedSessionId)
+}
+
+// verifyTargetResumed checks that the debugged process isn't traced anymore,
+// and resumes it with SIGCONT if it was left stopped.
+func (u *DlvDebuggerService) verifyTargetResumed(ctx context.Context) error {
+	if u.settings.UserSpecifiedPid == 0 {
+		log.Debug("debugged pid unknown, skipping the verification of its state")
+		return nil
+	}
+
+	tracerPid, state, err := u.targetStatus(ctx)
 	if err != nil {
-		return errors.Errorf("failed to convert the retrieved pid of dlv to an integer: %s", err)
+		log.WithError(err).Warnf("failed to verify the state of pid '%d'", u.settings.UserSpecifiedPid)
+		return nil
 	}
 
-	commandKill := []string{
-		"kill",
-		"-15",
-		strconv.Itoa(int(dlvPid)),
+	if tracerPid != "0" {
+		return errors.Errorf("pid '%d' is still traced by pid '%s'", u.settings.UserSpecifiedPid, tracerPid)
 	}
 
-	exitCode, err = u.kubernetesApiService.ExecuteCommand(u.settings.UserSpecifiedPodName, u.settings.UserSpecifiedContainer, commandKill, nil)
+	if strings.HasPrefix(state, "T") || strings.HasPrefix(state, "t") {
+		log.Warnf("pid '%d' is stopped (state: '%s'), resuming it", u.settings.UserSpecifiedPid, state)
 
-	if err != nil || exitCode != 0 {
-		return errors.Errorf("failed to kill dlv pid '%d' with exit code: '%d'", dlvPid, exitCode)
+		command := []string{"kill", "-CONT", strconv.Itoa(u.settings.UserSpecifiedPid)}
+		exitCode, err := u.kubernetesApiService.ExecuteCommand(ctx, u.settings.UserSpecifiedPodName, u.debuggerContainer(), command, nil)
+		if err != nil || exitCode != 0 {
+			return errors.Errorf("failed to resume pid '%d' with exit code: '%d'", u.settings.UserSpecifiedPid, exitCode)
+		}
 	}
 
-	log.Infof("remote dlv process killed")
+	log.Infof("pid '%d' detached and running", u.settings.UserSpecifiedPid)
 
 	return nil
 }
 
-func (u *DlvDebuggerService) Start(stdOut io.Writer) error {
+func (u *DlvDebuggerService) targetStatus(ctx context.Context) (string, string, error) {
+	command := []string{"cat", fmt.Sprintf("/proc/%d/status", u.settings.UserSpecifiedPid)}
+
+	output := new(kube.Writer)
+	exitCode, err := u.kubernetesApiService.ExecuteCommand(ctx, u.settings.UserSpecifiedPodName, u.debuggerContainer(), command, output)
+	if err != nil || exitCode != 0 {
+		return "", "", errors.Errorf("failed to read the status of pid '%d' with exit code: '%d'", u.settings.UserSpecifiedPid, exitCode)
+	}
+
+	var tracerPid, state string
+	for _, line := range strings.Split(output.Output, "\n") {
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+
+		switch key {
+		case "TracerPid":
+			tracerPid = strings.TrimSpace(value)
+		case "State":
+			state = strings.TrimSpace(value)
+		}
+	}
+
+	if tracerPid == "" || state == "" {
+		return "", "", errors.Errorf("unexpected status of pid '%d'", u.settings.UserSpecifiedPid)
+	}
+
+	return tracerPid, state, nil
+}
+
+// removeDlv removes the log and pid files of the session, and the uploaded
+// dlv binary unless another session runs it. The remote dlv path itself, e.g.
+// a dlv shipped by the image, is left alone.
+func (u *DlvDebuggerService) removeDlv(ctx context.Context) {
+	sessionPath := u.sessionPath()
+	cachedDlvPath := u.settings.DetectedCachedDlvPath
+
+	command := []string{"rm", "-f", sessionPath + ".log", sessionPath + ".pid"}
+	removed := sessionPath + ".{log,pid}"
+
+	uploaded, _ := path.Match(CachedDlvPattern(u.settings.DetectedRemoteDlvPath), cachedDlvPath)
+	switch {
+	case !uploaded:
+	case u.settings.DetectedDlvShared:
+		log.Infof("keeping the dlv binary: '%s' run by other sessions", cachedDlvPath)
+	default:
+		// the cache directories are removed when they are left empty
+		command = []string{"/bin/sh", "-c", `rm -f "$0" "$@" || exit 1
+dir=$(dirname "$0")
+rmdir "$dir" "$(dirname "$dir")" 2>/dev/null
+exit 0`,
+			cachedDlvPath, sessionPath + ".log", sessionPath + ".pid"}
+		removed = cachedDlvPath + " and " + removed
+	}
+
+	exitCode, err := u.kubernetesApiService.ExecuteCommand(ctx, u.settings.UserSpecifiedPodName, u.debuggerContainer(), command, nil)
+	if err != nil || exitCode != 0 {
+		log.Warnf("failed to remove '%s' from the remote container, exit code: '%d'", removed, exitCode)
+		return
+	}
+
+	log.Infof("removed '%s' from the remote container", removed)
+}
+
+// launchScript runs dlv ($1, with its arguments after the watchdog ones) with
+// its output redirected to a log file named after the session path ($0) and
+// its pid recorded in a pidfile, so that dlv doesn't depend on the exec session to
+// keep running, prints that pid and follows that log file until dlv exits. It
+// writes nothing before dlv is launched.
+//
+// Unless both its timeouts are 0, a watchdog running next to dlv stops it
+// after a ttl ($2 seconds), or once no client has been connected to the dlv
+// port ($4, hex as in /proc/net/tcp) for an idle timeout ($3 seconds), so that
+// the debugged process ($5) is detached and resumed even when dmm can't do it.
+const launchScript = `dlv=$1 ttl=$2 idle_timeout=$3 port=$4 target=$5
+shift 5
+watchdog() {
+  elapsed=0 idle=0
+  while sleep 5 && kill -0 $pid 2>/dev/null; do
+    elapsed=$((elapsed+5))
+    if [ $ttl -gt 0 ] && [ $elapsed -ge $ttl ]; then
+      echo "dmm watchdog: ttl of ${ttl}s reached, stopping dlv" >> "$0.log"
+      break
+    fi
+    if [ $idle_timeout -gt 0 ]; then
+      clients=$(cat /proc/$pid/net/tcp /proc/$pid/net/tcp6 2>/dev/null | {
+        n=0
+        while read -r sl local remote st rest; do
+          case "$local" in *:$port) [ "$st" = 01 ] && n=$((n+1)) ;; esac
+        done
+        echo $n
+      })
+      if [ "$clients" = 0 ]; then idle=$((idle+5)); else idle=0; fi
+      if [ $idle -ge $idle_timeout ]; then
+        echo "dmm watchdog: no client for ${idle_timeout}s, stopping dlv" >> "$0.log"
+        break
+      fi
+    fi
+  done
+  kill -0 $pid 2>/dev/null || return
+  kill -TERM $pid
+  i=0
+  while kill -0 $pid 2>/dev/null && [ $i -lt 10 ]; do sleep 1; i=$((i+1)); done
+  kill -KILL $pid 2>/dev/null
+  kill -CONT $target 2>/dev/null
+}
+: > "$0.log"
+"$dlv" "$@" >> "$0.log" 2>&1 &
+pid=$!
+echo $pid > "$0.pid"
+echo "dlv launched, pid: $pid"
+watchdog_pid=""
+if [ $ttl -gt 0 ] || [ $idle_timeout -gt 0 ]; then
+  watchdog &
+  watchdog_pid=$!
+fi
+tail -f "$0.log" &
+tail_pid=$!
+wait $pid
+code=$?
+kill $tail_pid $watchdog_pid 2>/dev/null
+exit $code`
+
+// watchdogSeconds rounds a watchdog timeout up to seconds, 0 disabling it.
+func watchdogSeconds(timeout time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(timeout.Seconds())))
+}
+
+// launchWriter forwards the output of the launch script and records whether
+// there was any, which tells that dlv was launched.
+type launchWriter struct {
+	io.Writer
+	launched atomic.Bool
+}
+
+func (w *launchWriter) Write(p []byte) (int, error) {
+	w.launched.Store(true)
+	return w.Writer.Write(p)
+}
+
+func (u *DlvDebuggerService) Start(ctx context.Context, stdOut io.Writer) error {
 	log.Info("start debugging on remote container")
 
 	command := []string{
-		u.settings.UserSpecifiedRemoteDlvPath,
+		"/bin/sh",
+		"-c",
+		launchScript,
+		u.sessionPath(),
+		u.settings.DetectedCachedDlvPath,
+		watchdogSeconds(u.settings.UserSpecifiedTtl),
+		watchdogSeconds(u.settings.UserSpecifiedIdleTimeout),
+		fmt.Sprintf("%04X", u.settings.UserSpecifiedDebuggerPort),
+		strconv.Itoa(u.settings.UserSpecifiedPid),
 		"attach",
 		strconv.Itoa(u.settings.UserSpecifiedPid),
 		"--continue",
@@ -101,12 +516,24 @@ func (u *DlvDebuggerService) Start(stdOut io.Writer) error {
 		"--api-version=2",
 	}
 
-	exitCode, err := u.kubernetesApiService.ExecuteCommand(u.settings.UserSpecifiedPodName, u.settings.UserSpecifiedContainer, command, stdOut)
-	if err != nil || exitCode != 0 {
+	output := &launchWriter{Writer: stdOut}
+
+	exitCode, err := u.kubernetesApiService.ExecuteCommand(ctx, u.settings.UserSpecifiedPodName, u.debuggerContainer(), command, output)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil && output.launched.Load() {
+		return errors.Wrapf(ErrLogStreamLost, "%s", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	if exitCode != 0 {
 		return errors.Errorf("executing debugger failed, exit code: '%d'", exitCode)
 	}
 
-	log.Infof("debugging on remote container")
+	log.Infof("debugger exited on remote container")
 
 	return nil
 }
@@ -0,0 +1,369 @@
+package debugger
+
+import (
+	"context"
+	"debug-me-maybe/kube"
+	"debug-me-maybe/pkg/config"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// localApiService runs the commands of the debugger service locally, as if the
+// remote container shared the filesystem and the processes of the test.
+type localApiService struct {
+	kube.KubernetesApiService
+	verified []string
+}
+
+func (l *localApiService) ExecuteCommand(ctx context.Context, podName string, containerName string, command []string, stdOut io.Writer) (int, error) {
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdout = stdOut
+
+	err := cmd.Run()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return -1, err
+	}
+
+	return 0, nil
+}
+
+func (l *localApiService) EnsureEphemeralContainer(ctx context.Context, podName string, targetContainerName string, image string) (string, error) {
+	return "dmm-debugger-test", nil
+}
+
+func (l *localApiService) VerifyFile(ctx context.Context, localPath string, remotePath string, podName string, containerName string) error {
+	l.verified = append(l.verified, remotePath)
+	return nil
+}
+
+func TestSetupImageShippedDlv(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  config.UploadMethod
+		mode    os.FileMode
+		shipped bool
+	}{
+		{name: "ephemeral image ships dlv", method: config.EPHEMERAL, mode: 0755, shipped: true},
+		{name: "ephemeral image ships a non executable dlv", method: config.EPHEMERAL, mode: 0644},
+		{name: "ephemeral image without dlv", method: config.EPHEMERAL},
+		{name: "direct with dlv at the remote path", method: config.DIRECT, mode: 0755},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			remoteDlvPath := filepath.Join(t.TempDir(), "dlv")
+			if test.mode != 0 {
+				if err := os.WriteFile(remoteDlvPath, []byte("#!/bin/sh\n"), test.mode); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cachedDlvPath := CachedDlvPath(remoteDlvPath, "3f2a")
+			settings := &config.DMMSettings{
+				UserSpecifiedUploadMethod: test.method,
+				DetectedRemoteDlvPath:     remoteDlvPath,
+				DetectedCachedDlvPath:     cachedDlvPath,
+			}
+			service := &localApiService{}
+
+			if err := NewUploadDlvRemoteDebuggingService(settings, service).Setup(context.Background()); err != nil {
+				t.Fatalf("Setup() failed: %s", err)
+			}
+
+			wantDlvPath, wantVerified := cachedDlvPath, []string{cachedDlvPath}
+			if test.shipped {
+				wantDlvPath, wantVerified = remoteDlvPath, nil
+			}
+
+			if settings.DetectedCachedDlvPath != wantDlvPath {
+				t.Errorf("Setup() runs dlv from '%s', want '%s'", settings.DetectedCachedDlvPath, wantDlvPath)
+			}
+
+			if !reflect.DeepEqual(service.verified, wantVerified) {
+				t.Errorf("Setup() verified %v, want %v", service.verified, wantVerified)
+			}
+		})
+	}
+}
+
+// fakeDlv copies sleep to path, for the processes running it to be told apart
+// by their argv[0] like dlv.
+func fakeDlv(t *testing.T, path string) {
+	t.Helper()
+
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep not found")
+	}
+
+	content, err := os.ReadFile(sleep)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, content, 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startProcess runs a command until the end of the test.
+func startProcess(t *testing.T, name string, args ...string) *exec.Cmd {
+	t.Helper()
+
+	cmd := exec.Command(name, args...)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+
+	return cmd
+}
+
+func runScript(t *testing.T, script string, args ...string) string {
+	t.Helper()
+
+	output, err := exec.Command("/bin/sh", append([]string{"-c", script}, args...)...).Output()
+	if err != nil {
+		t.Fatalf("script failed: %s", err)
+	}
+
+	return strings.TrimSpace(string(output))
+}
+
+func TestFindDlvPidScript(t *testing.T) {
+	dir := t.TempDir()
+	remoteDlvPath := filepath.Join(dir, "dlv")
+	cachedDlvPath := CachedDlvPath(remoteDlvPath, "3f2a")
+	fakeDlv(t, remoteDlvPath)
+	fakeDlv(t, cachedDlvPath)
+
+	remote := startProcess(t, remoteDlvPath, "60")
+	cached := startProcess(t, cachedDlvPath, "60")
+	other := startProcess(t, "sleep", "60")
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		pidfile string
+		want    string
+	}{
+		{name: "remote dlv path", pidfile: strconv.Itoa(remote.Process.Pid), want: strconv.Itoa(remote.Process.Pid)},
+		{name: "cached dlv", pidfile: strconv.Itoa(cached.Process.Pid), want: strconv.Itoa(cached.Process.Pid)},
+		{name: "recycled pid", pidfile: strconv.Itoa(other.Process.Pid)},
+		{name: "exited", pidfile: strconv.Itoa(exited.Process.Pid)},
+		{name: "no pidfile"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sessionPath := SessionPath(remoteDlvPath, "x7k2p9qa")
+			_ = os.Remove(sessionPath + ".pid")
+			if test.pidfile != "" {
+				if err := os.WriteFile(sessionPath+".pid", []byte(test.pidfile+"\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if pid := runScript(t, findDlvPidScript, sessionPath, remoteDlvPath, CachedDlvPattern(remoteDlvPath)); pid != test.want {
+				t.Errorf("findDlvPidScript = %q, want %q", pid, test.want)
+			}
+		})
+	}
+}
+
+func TestScanDlvPidsScript(t *testing.T) {
+	dir := t.TempDir()
+	remoteDlvPath := filepath.Join(dir, "dlv")
+	fakeDlv(t, remoteDlvPath)
+	fakeDlv(t, CachedDlvPath(remoteDlvPath, "3f2a"))
+	fakeDlv(t, filepath.Join(dir, "other", "dlv"))
+
+	want := []string{
+		strconv.Itoa(startProcess(t, remoteDlvPath, "60").Process.Pid),
+		strconv.Itoa(startProcess(t, CachedDlvPath(remoteDlvPath, "3f2a"), "60").Process.Pid),
+	}
+	startProcess(t, filepath.Join(dir, "other", "dlv"), "60")
+	startProcess(t, "sleep", "60")
+
+	pids := strings.Fields(runScript(t, scanDlvPidsScript, remoteDlvPath, CachedDlvPattern(remoteDlvPath)))
+	sort.Strings(pids)
+	sort.Strings(want)
+
+	if !reflect.DeepEqual(pids, want) {
+		t.Errorf("scanDlvPidsScript = %v, want %v", pids, want)
+	}
+}
+
+func TestKillScript(t *testing.T) {
+	tests := []struct {
+		name    string
+		command []string
+		killed  bool
+	}{
+		{name: "exits on SIGTERM", command: []string{"sleep", "60"}},
+		{name: "ignores SIGTERM", command: []string{"/bin/sh", "-c", `trap "" TERM; exec sleep 60`}, killed: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			process := startProcess(t, test.command[0], test.command[1:]...)
+			pid := strconv.Itoa(process.Process.Pid)
+
+			// let the shell ignore SIGTERM before it is sent
+			time.Sleep(100 * time.Millisecond)
+
+			output := runScript(t, killScript, "1", pid)
+			if killed := output == pid; killed != test.killed {
+				t.Errorf("killScript = %q, want SIGKILL sent: %t", output, test.killed)
+			}
+
+			if err := process.Wait(); err == nil {
+				t.Errorf("process exited normally, want it killed")
+			}
+		})
+	}
+}
+
+func TestLaunchScript(t *testing.T) {
+	dir := t.TempDir()
+	dlv := filepath.Join(dir, "dlv")
+	if err := os.WriteFile(dlv, []byte("#!/bin/sh\necho \"dlv $*\"\nexit 3\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	sessionPath := SessionPath(dlv, "x7k2p9qa")
+
+	cmd := exec.Command("/bin/sh", "-c", launchScript, sessionPath, dlv, "0", "0", "0929", "1", "attach", "1")
+	output, err := cmd.Output()
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Errorf("launchScript exited with %v, want the exit code of dlv: 3", err)
+	}
+
+	pid, err := os.ReadFile(sessionPath + ".pid")
+	if err != nil {
+		t.Fatalf("launchScript didn't write the pidfile: %s", err)
+	}
+
+	if first := strings.SplitN(string(output), "\n", 2)[0]; first != "dlv launched, pid: "+strings.TrimSpace(string(pid)) {
+		t.Errorf("launchScript printed %q first, want the pid of dlv: %s", first, pid)
+	}
+
+	log, err := os.ReadFile(sessionPath + ".log")
+	if err != nil || string(log) != "dlv attach 1\n" {
+		t.Errorf("launchScript logged %q (%v), want the output of dlv", log, err)
+	}
+}
+
+func TestCleanupWithoutSession(t *testing.T) {
+	dir := t.TempDir()
+	remoteDlvPath := filepath.Join(dir, "dlv")
+	fakeDlv(t, remoteDlvPath)
+	fakeDlv(t, CachedDlvPath(remoteDlvPath, "3f2a"))
+
+	settings := &config.DMMSettings{DetectedRemoteDlvPath: remoteDlvPath}
+	service := NewUploadDlvRemoteDebuggingService(settings, &localApiService{})
+
+	if err := service.Cleanup(context.Background()); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("Cleanup() without dlv = %v, want %v", err, ErrNotRunning)
+	}
+
+	processes := []*exec.Cmd{
+		startProcess(t, remoteDlvPath, "60"),
+		startProcess(t, CachedDlvPath(remoteDlvPath, "3f2a"), "60"),
+	}
+
+	if err := service.Cleanup(context.Background()); err != nil {
+		t.Fatalf("Cleanup() failed: %s", err)
+	}
+
+	for _, process := range processes {
+		if err := process.Wait(); err == nil {
+			t.Errorf("dlv pid '%d' exited normally, want it killed", process.Process.Pid)
+		}
+	}
+}
+
+func TestRemoveDlv(t *testing.T) {
+	tests := []struct {
+		name    string
+		shipped bool
+		shared  bool
+		removed bool
+	}{
+		{name: "uploaded dlv", removed: true},
+		{name: "dlv run by other sessions", shared: true},
+		{name: "dlv shipped by the image", shipped: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			remoteDlvPath := filepath.Join(t.TempDir(), "dlv")
+			cachedDlvPath := CachedDlvPath(remoteDlvPath, "3f2a")
+			if test.shipped {
+				cachedDlvPath = remoteDlvPath
+			}
+
+			sessionPath := SessionPath(remoteDlvPath, "x7k2p9qa")
+			for _, file := range []string{remoteDlvPath, cachedDlvPath, sessionPath + ".log", sessionPath + ".pid"} {
+				if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(file, nil, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			settings := &config.DMMSettings{
+				DetectedRemoteDlvPath: remoteDlvPath,
+				DetectedCachedDlvPath: cachedDlvPath,
+				DetectedDlvShared:     test.shared,
+				DetectedSessionId:     "x7k2p9qa",
+			}
+			service := &DlvDebuggerService{settings: settings, kubernetesApiService: &localApiService{}}
+
+			service.removeDlv(context.Background())
+
+			for _, file := range []string{sessionPath + ".log", sessionPath + ".pid"} {
+				if _, err := os.Stat(file); !os.IsNotExist(err) {
+					t.Errorf("removeDlv() kept '%s'", file)
+				}
+			}
+
+			if _, err := os.Stat(remoteDlvPath); err != nil {
+				t.Errorf("removeDlv() removed the remote dlv path: %v", err)
+			}
+
+			if _, err := os.Stat(filepath.Dir(filepath.Dir(cachedDlvPath))); test.removed != os.IsNotExist(err) {
+				t.Errorf("removeDlv() removed the cache directory of '%s': %t, want %t", cachedDlvPath, os.IsNotExist(err), test.removed)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package forwarder
+
+import (
+	"context"
+	"debug-me-maybe/kube"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const initialBackoff = time.Second
+const maximumBackoff = 30 * time.Second
+
+// ForwarderService keeps a port-forward to a pod open until it is stopped,
+// reconnecting with an exponential backoff whenever the connection to the pod
+// is lost (API server restart, idle timeout, laptop sleep...). While
+// connected, the underlying SPDY connection is kept alive with pings.
+type ForwarderService struct {
+	kubernetesApiService kube.KubernetesApiService
+	podName              string
+	localPort            int
+	remotePort           int
+	out                  io.Writer
+	readyCh              chan int
+	established          bool
+}
+
+func NewForwarderService(service kube.KubernetesApiService, podName string, localPort int, remotePort int, out io.Writer) *ForwarderService {
+	return &ForwarderService{
+		kubernetesApiService: service,
+		podName:              podName,
+		localPort:            localPort,
+		remotePort:           remotePort,
+		out:                  out,
+		readyCh:              make(chan int, 1),
+	}
+}
+
+// Ready receives the local port once the port-forward is first established.
+func (f *ForwarderService) Ready() <-chan int {
+	return f.readyCh
+}
+
+// Run forwards the port until ctx is done. It only returns an error when
+// the port-forward could never be established.
+func (f *ForwarderService) Run(ctx context.Context) error {
+	backoff := initialBackoff
+
+	for {
+		connected, err := f.forward(ctx)
+		if connected {
+			backoff = initialBackoff
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		if !f.established {
+			return errors.Wrap(err, "failed to establish port-forward")
+		}
+
+		if err != nil {
+			log.WithError(err).Warnf("port-forward failed, reconnecting in %s", backoff)
+		} else {
+			log.Warnf("lost connection to pod: '%s', reconnecting in %s", f.podName, backoff)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maximumBackoff {
+			backoff = maximumBackoff
+		}
+	}
+}
+
+// forward runs a single port-forward until it breaks or is stopped, and
+// reports whether it was established.
+func (f *ForwarderService) forward(ctx context.Context) (bool, error) {
+	readyCh := make(chan struct{})
+
+	forwarder, err := f.kubernetesApiService.PortForward(ctx, f.podName, f.localPort, f.remotePort, readyCh, f.out)
+	if err != nil {
+		return false, err
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- forwarder.ForwardPorts()
+	}()
+
+	select {
+	case <-readyCh:
+	case err := <-errCh:
+		return false, err
+	}
+
+	ports, err := forwarder.GetPorts()
+	if err != nil {
+		forwarder.Close()
+		return false, err
+	}
+
+	if !f.established {
+		// reconnections must reuse the port the debugger clients know about
+		f.localPort = int(ports[0].Local)
+		f.established = true
+		f.readyCh <- f.localPort
+	} else {
+		log.Infof("port-forward to pod: '%s' re-established on local port: '%d'", f.podName, f.localPort)
+	}
+
+	return true, <-errCh
+}
@@ -0,0 +1,163 @@
+package forwarder
+
+import (
+	"context"
+	"debug-me-maybe/kube"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/tools/portforward"
+)
+
+// fakeConnection is a streaming connection to the pod that is lost once
+// closed.
+type fakeConnection struct {
+	closeCh chan bool
+	once    sync.Once
+}
+
+func (c *fakeConnection) CreateStream(headers http.Header) (httpstream.Stream, error) {
+	return nil, errors.New("no stream on a fake connection")
+}
+
+func (c *fakeConnection) Close() error {
+	c.once.Do(func() { close(c.closeCh) })
+	return nil
+}
+
+func (c *fakeConnection) CloseChan() <-chan bool {
+	return c.closeCh
+}
+
+func (c *fakeConnection) SetIdleTimeout(timeout time.Duration) {}
+
+func (c *fakeConnection) RemoveStreams(streams ...httpstream.Stream) {}
+
+type fakeDialer struct {
+	connection *fakeConnection
+	err        error
+}
+
+func (d *fakeDialer) Dial(protocols ...string) (httpstream.Connection, string, error) {
+	if d.err != nil {
+		return nil, "", d.err
+	}
+
+	return d.connection, portforward.PortForwardProtocolV1Name, nil
+}
+
+// fakeApiService port-forwards over fake connections, the first attempts
+// failing to dial with dialErrors, and records the requested local ports.
+type fakeApiService struct {
+	kube.KubernetesApiService
+	mutex       sync.Mutex
+	connections chan *fakeConnection
+	localPorts  []int
+	dialErrors  []error
+}
+
+func (f *fakeApiService) PortForward(ctx context.Context, podName string, localPort int, remotePort int,
+	readyCh chan struct{}, out io.Writer) (*portforward.PortForwarder, error) {
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	dialer := &fakeDialer{connection: &fakeConnection{closeCh: make(chan bool)}}
+	if attempt := len(f.localPorts); attempt < len(f.dialErrors) {
+		dialer.err = f.dialErrors[attempt]
+	}
+
+	f.localPorts = append(f.localPorts, localPort)
+
+	if dialer.err == nil {
+		f.connections <- dialer.connection
+	}
+
+	return portforward.NewOnAddresses(dialer, []string{"127.0.0.1"}, []string{fmt.Sprintf("%d:%d", localPort, remotePort)},
+		ctx.Done(), readyCh, out, out)
+}
+
+func (f *fakeApiService) requestedPorts() []int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return append([]int(nil), f.localPorts...)
+}
+
+func TestRunReconnects(t *testing.T) {
+	service := &fakeApiService{connections: make(chan *fakeConnection, 3)}
+	forwarderService := NewForwarderService(service, "operator-0", 0, 2345, io.Discard)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- forwarderService.Run(ctx)
+	}()
+
+	var localPort int
+	select {
+	case localPort = <-forwarderService.Ready():
+	case err := <-errCh:
+		t.Fatalf("Run() = %v before the port-forward was ready", err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("port-forward not ready")
+	}
+
+	if localPort == 0 {
+		t.Fatal("Ready() = 0, want the local port picked for the port-forward")
+	}
+
+	// the connection to the pod is lost, twice
+	for i := 0; i < 2; i++ {
+		select {
+		case connection := <-service.connections:
+			_ = connection.Close()
+		case <-time.After(10 * time.Second):
+			t.Fatalf("port-forward %d not established", i+1)
+		}
+	}
+
+	select {
+	case <-service.connections:
+	case <-time.After(10 * time.Second):
+		t.Fatal("port-forward not re-established")
+	}
+
+	cancel()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Errorf("Run() = %v once stopped, want nil", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run() didn't return once stopped")
+	}
+
+	ports := service.requestedPorts()
+	if len(ports) != 3 || ports[0] != 0 || ports[1] != localPort || ports[2] != localPort {
+		t.Errorf("requested local ports = %v, want [0 %d %d]", ports, localPort, localPort)
+	}
+}
+
+func TestRunNeverEstablished(t *testing.T) {
+	service := &fakeApiService{connections: make(chan *fakeConnection, 1), dialErrors: []error{errors.New("forbidden")}}
+	forwarderService := NewForwarderService(service, "operator-0", 0, 2345, io.Discard)
+
+	err := forwarderService.Run(context.Background())
+	if err == nil {
+		t.Fatal("Run() = nil, want an error when the port-forward is never established")
+	}
+
+	if ports := service.requestedPorts(); len(ports) != 1 {
+		t.Errorf("requested local ports = %v, want a single attempt", ports)
+	}
+}
@@ -0,0 +1,79 @@
+package gc
+
+import (
+	"context"
+	"debug-me-maybe/kube"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Resource is a stager resource found by the garbage collector.
+type Resource struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Session   string
+	Owner     string
+	ExpiresAt time.Time
+}
+
+// GcService finds the stager resources left behind when the plugin was killed
+// during a stager upload, before it could delete them.
+type GcService struct {
+	kubernetesApiService kube.KubernetesApiService
+}
+
+func NewGcService(service kube.KubernetesApiService) *GcService {
+	return &GcService{kubernetesApiService: service}
+}
+
+// ExpiredStagerResources returns the stager pods, services and network
+// policies of the namespace of the service, or of all namespaces when it is
+// empty, that outlived their expiry. Resources created before expiries were
+// recorded expire after kube.StagerLifetime.
+func (g *GcService) ExpiredStagerResources(ctx context.Context, now time.Time) ([]Resource, error) {
+	resources, err := g.kubernetesApiService.ListStagerResources(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []Resource
+	for _, resource := range resources {
+		if r, ok := expiredResource(resource.Kind, resource.Meta, now); ok {
+			expired = append(expired, r)
+		}
+	}
+
+	return expired, nil
+}
+
+func expiredResource(kind string, meta v1.ObjectMeta, now time.Time) (Resource, bool) {
+	expiresAt := meta.CreationTimestamp.Add(kube.StagerLifetime)
+	if value, found := meta.Annotations[kube.ExpiryAnnotation]; found {
+		if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+			expiresAt = parsed
+		} else {
+			log.WithError(err).Debugf("invalid expiry of %s: '%s'", kind, meta.Name)
+		}
+	}
+
+	if now.Before(expiresAt) {
+		return Resource{}, false
+	}
+
+	return Resource{
+		Kind:      kind,
+		Namespace: meta.Namespace,
+		Name:      meta.Name,
+		Session:   meta.Labels[kube.SessionLabel],
+		Owner:     meta.Labels[kube.OwnerLabel],
+		ExpiresAt: expiresAt,
+	}, true
+}
+
+// Delete deletes a stager resource.
+func (g *GcService) Delete(ctx context.Context, resource Resource) error {
+	return g.kubernetesApiService.DeleteStagerResource(ctx, resource.Kind, resource.Namespace, resource.Name)
+}
@@ -0,0 +1,65 @@
+package gc
+
+import (
+	"debug-me-maybe/kube"
+	"testing"
+	"time"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestExpiredResource(t *testing.T) {
+	created := time.Date(2023, 3, 14, 9, 0, 0, 0, time.UTC)
+	expiresAt := created.Add(10 * time.Minute)
+	labels := map[string]string{kube.SessionLabel: "x7k2p9qa", kube.OwnerLabel: "jane"}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		now         time.Time
+		expired     bool
+		expiresAt   time.Time
+	}{
+		{name: "annotation not reached", now: expiresAt.Add(-time.Second), expired: false,
+			annotations: map[string]string{kube.ExpiryAnnotation: expiresAt.Format(time.RFC3339)}},
+		{name: "annotation reached", now: expiresAt, expired: true, expiresAt: expiresAt,
+			annotations: map[string]string{kube.ExpiryAnnotation: expiresAt.Format(time.RFC3339)}},
+		{name: "annotation passed", now: expiresAt.Add(time.Minute), expired: true, expiresAt: expiresAt,
+			annotations: map[string]string{kube.ExpiryAnnotation: expiresAt.Format(time.RFC3339)}},
+		{name: "no annotation within lifetime", now: created.Add(kube.StagerLifetime - time.Second), expired: false},
+		{name: "no annotation past lifetime", now: created.Add(kube.StagerLifetime), expired: true,
+			expiresAt: created.Add(kube.StagerLifetime)},
+		{name: "invalid annotation within lifetime", now: expiresAt.Add(time.Minute), expired: false,
+			annotations: map[string]string{kube.ExpiryAnnotation: "tomorrow"}},
+		{name: "invalid annotation past lifetime", now: created.Add(kube.StagerLifetime), expired: true,
+			expiresAt: created.Add(kube.StagerLifetime), annotations: map[string]string{kube.ExpiryAnnotation: "tomorrow"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			meta := v1.ObjectMeta{
+				Namespace:         "shop",
+				Name:              "dmm-stager-x7k2p9qa",
+				CreationTimestamp: v1.NewTime(created),
+				Labels:            labels,
+				Annotations:       test.annotations,
+			}
+
+			resource, expired := expiredResource("pod", meta, test.now)
+			if expired != test.expired {
+				t.Fatalf("expiredResource(%v) expired = %t, want %t", test.now, expired, test.expired)
+			}
+
+			if !expired {
+				return
+			}
+
+			want := Resource{Kind: "pod", Namespace: "shop", Name: "dmm-stager-x7k2p9qa",
+				Session: "x7k2p9qa", Owner: "jane", ExpiresAt: test.expiresAt}
+			if resource.Kind != want.Kind || resource.Namespace != want.Namespace || resource.Name != want.Name ||
+				resource.Session != want.Session || resource.Owner != want.Owner || !resource.ExpiresAt.Equal(want.ExpiresAt) {
+				t.Errorf("expiredResource(%v) = %+v, want %+v", test.now, resource, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package lease
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const minimumRenewInterval = time.Second
+
+// LeaseKeeperService renews the leader election Lease held by the debugged pod
+// on its behalf, so that the Lease doesn't expire while the process is halted
+// in the debugger and another replica doesn't take over.
+type LeaseKeeperService struct {
+	clientset *kubernetes.Clientset
+	namespace string
+	leaseName string
+	podName   string
+	holder    string
+}
+
+func NewLeaseKeeperService(clientset *kubernetes.Clientset, namespace string, leaseName string, podName string) *LeaseKeeperService {
+	return &LeaseKeeperService{clientset: clientset, namespace: namespace, leaseName: leaseName, podName: podName}
+}
+
+// Detect finds the Lease held by the pod: controller-runtime and client-go
+// leader election use the pod's hostname, optionally followed by '_' and a
+// unique ID, as holder identity.
+func (l *LeaseKeeperService) Detect(ctx context.Context) error {
+	var candidates []coordinationv1.Lease
+
+	if l.leaseName != "" {
+		lease, err := l.clientset.CoordinationV1().Leases(l.namespace).Get(ctx, l.leaseName, v1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		candidates = append(candidates, *lease)
+	} else {
+		leases, err := l.clientset.CoordinationV1().Leases(l.namespace).List(ctx, v1.ListOptions{})
+		if err != nil {
+			return err
+		}
+		candidates = leases.Items
+	}
+
+	for _, lease := range candidates {
+		if lease.Spec.HolderIdentity == nil || !l.isHeldByPod(*lease.Spec.HolderIdentity) {
+			continue
+		}
+
+		l.leaseName = lease.Name
+		l.holder = *lease.Spec.HolderIdentity
+
+		log.Infof("pod '%s' holds leader election lease: '%s' (holder: '%s')", l.podName, l.leaseName, l.holder)
+
+		return nil
+	}
+
+	if l.leaseName != "" {
+		return errors.Errorf("lease: '%s' is not held by pod: '%s'", l.leaseName, l.podName)
+	}
+
+	return errors.Errorf("no lease held by pod: '%s' found in namespace: '%s'", l.podName, l.namespace)
+}
+
+func (l *LeaseKeeperService) isHeldByPod(holder string) bool {
+	return holder == l.podName || strings.HasPrefix(holder, l.podName+"_")
+}
+
+// Run renews the Lease until ctx is done, or until the Lease is acquired by
+// another holder.
+func (l *LeaseKeeperService) Run(ctx context.Context) {
+	interval := minimumRenewInterval
+
+	for {
+		lease, err := l.clientset.CoordinationV1().Leases(l.namespace).Get(ctx, l.leaseName, v1.GetOptions{})
+		switch {
+		case ctx.Err() != nil:
+			// stopped, reported below
+		case err != nil:
+			log.WithError(err).Warnf("failed to get lease: '%s'", l.leaseName)
+		case lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != l.holder:
+			log.Warnf("lease: '%s' isn't held by '%s' anymore, stopping its renewal", l.leaseName, l.holder)
+			return
+		default:
+			interval = renewInterval(lease)
+
+			now := v1.NewMicroTime(time.Now())
+			lease.Spec.RenewTime = &now
+
+			_, err := l.clientset.CoordinationV1().Leases(l.namespace).Update(ctx, lease, v1.UpdateOptions{})
+			if err != nil && ctx.Err() == nil {
+				log.WithError(err).Warnf("failed to renew lease: '%s'", l.leaseName)
+			} else if err == nil {
+				log.Debugf("renewed lease: '%s' on behalf of '%s'", l.leaseName, l.holder)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Infof("stopped renewing lease: '%s', '%s' renews it again once resumed", l.leaseName, l.holder)
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// renewInterval renews the lease three times per lease duration.
+func renewInterval(lease *coordinationv1.Lease) time.Duration {
+	if lease.Spec.LeaseDurationSeconds == nil {
+		return minimumRenewInterval
+	}
+
+	interval := time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second / 3
+	if interval < minimumRenewInterval {
+		return minimumRenewInterval
+	}
+
+	return interval
+}
@@ -0,0 +1,229 @@
+package preflight
+
+import (
+	"context"
+	"debug-me-maybe/pkg/config"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Permission is a verb on a resource, e.g. 'create pods/exec'. Without an
+// optional permission, the session works in a degraded way, e.g. with less
+// detailed errors.
+type Permission struct {
+	Verb          string
+	Group         string
+	Resource      string
+	Subresource   string
+	ClusterScoped bool
+	Optional      bool
+}
+
+func (p Permission) String() string {
+	resource := p.Resource
+	if p.Group != "" {
+		resource += "." + p.Group
+	}
+
+	if p.Subresource != "" {
+		resource += "/" + p.Subresource
+	}
+
+	return p.Verb + " " + resource
+}
+
+// workloadPermissions are the permissions needed to resolve the pods of a
+// workload targeted as TYPE/NAME, by its normalized kind.
+var workloadPermissions = map[string][]Permission{
+	// the pods of a deployment are owned by its replicasets
+	"deployment": {
+		{Verb: "get", Group: "apps", Resource: "deployments"},
+		{Verb: "list", Group: "apps", Resource: "replicasets"},
+	},
+	"statefulset": {{Verb: "get", Group: "apps", Resource: "statefulsets"}},
+	"daemonset":   {{Verb: "get", Group: "apps", Resource: "daemonsets"}},
+	"job":         {{Verb: "get", Group: "batch", Resource: "jobs"}},
+}
+
+// RequiredPermissions returns the permissions needed in the target namespace
+// for a debugging session with the given settings.
+func RequiredPermissions(settings *config.DMMSettings) []Permission {
+	permissions := []Permission{
+		{Verb: "get", Resource: "pods"},
+		{Verb: "create", Resource: "pods", Subresource: "exec"},
+		{Verb: "create", Resource: "pods", Subresource: "portforward"},
+		// the sessions of the namespace aren't listed without it, e.g. by
+		// 'dmm session list'
+		{Verb: "list", Resource: "pods", Optional: true},
+		// the session is only recorded locally without it
+		{Verb: "patch", Resource: "pods", Optional: true},
+		// the architecture is read with uname in the container without it
+		{Verb: "get", Resource: "nodes", ClusterScoped: true, Optional: true},
+	}
+
+	// the pods of a workload or selector are listed to pick one
+	if settings.UserSpecifiedWorkloadKind != "" || settings.UserSpecifiedSelector != "" {
+		permissions = addPermission(permissions, Permission{Verb: "list", Resource: "pods"})
+	}
+
+	for _, permission := range workloadPermissions[settings.UserSpecifiedWorkloadKind] {
+		permissions = addPermission(permissions, permission)
+	}
+
+	if settings.UserSpecifiedDisableProbes {
+		// the deployment owning a pod is found through its replicaset, and the
+		// pod replacing the debugged one among the pods of the workload
+		permissions = addPermission(permissions, Permission{Verb: "get", Group: "apps", Resource: "replicasets"})
+		permissions = addPermission(permissions, Permission{Verb: "list", Group: "apps", Resource: "replicasets"})
+		permissions = addPermission(permissions, Permission{Verb: "list", Resource: "pods"})
+
+		for _, resource := range []string{"deployments", "statefulsets", "daemonsets"} {
+			permissions = addPermission(permissions, Permission{Verb: "get", Group: "apps", Resource: resource})
+			permissions = addPermission(permissions, Permission{Verb: "patch", Group: "apps", Resource: resource})
+		}
+	}
+
+	switch settings.UserSpecifiedUploadMethod {
+	case config.STAGER:
+		for _, permission := range []Permission{
+			{Verb: "create", Resource: "pods"},
+			{Verb: "delete", Resource: "pods"},
+			// the stager pod is waited for by listing then watching it
+			{Verb: "list", Resource: "pods"},
+			{Verb: "watch", Resource: "pods"},
+			{Verb: "create", Resource: "services"},
+			{Verb: "delete", Resource: "services"},
+			{Verb: "list", Group: "discovery.k8s.io", Resource: "endpointslices"},
+			{Verb: "watch", Group: "discovery.k8s.io", Resource: "endpointslices"},
+			// the blocking network policies and the events of a stager pod
+			// that doesn't start aren't reported without them
+			{Verb: "list", Group: "networking.k8s.io", Resource: "networkpolicies", Optional: true},
+			{Verb: "list", Resource: "events", Optional: true},
+		} {
+			permissions = addPermission(permissions, permission)
+		}
+
+		if settings.UserSpecifiedStagerPolicy {
+			permissions = append(permissions,
+				Permission{Verb: "create", Group: "networking.k8s.io", Resource: "networkpolicies"},
+				Permission{Verb: "delete", Group: "networking.k8s.io", Resource: "networkpolicies"},
+			)
+		}
+	case config.EPHEMERAL:
+		permissions = append(permissions, Permission{Verb: "update", Resource: "pods", Subresource: "ephemeralcontainers"})
+	}
+
+	if settings.UserSpecifiedKeepLease {
+		permissions = append(permissions,
+			Permission{Verb: "list", Group: "coordination.k8s.io", Resource: "leases"},
+			Permission{Verb: "update", Group: "coordination.k8s.io", Resource: "leases"},
+		)
+	}
+
+	return permissions
+}
+
+// addPermission adds a permission once, a permission being required when any
+// of the steps needing it can't do without.
+func addPermission(permissions []Permission, permission Permission) []Permission {
+	for i, p := range permissions {
+		if p.Verb == permission.Verb && p.Group == permission.Group && p.Resource == permission.Resource &&
+			p.Subresource == permission.Subresource && p.ClusterScoped == permission.ClusterScoped {
+			permissions[i].Optional = p.Optional && permission.Optional
+			return permissions
+		}
+	}
+
+	return append(permissions, permission)
+}
+
+// AccessReviewService checks the permissions of the user with
+// SelfSubjectAccessReviews, so that missing ones are reported before anything
+// is created in the cluster.
+type AccessReviewService struct {
+	clientset   *kubernetes.Clientset
+	namespace   string
+	permissions []Permission
+}
+
+func NewAccessReviewService(clientset *kubernetes.Clientset, namespace string, permissions []Permission) *AccessReviewService {
+	return &AccessReviewService{clientset: clientset, namespace: namespace, permissions: permissions}
+}
+
+// Run returns the check of the permissions, which fails when any of them is
+// denied.
+func (a *AccessReviewService) Run(ctx context.Context) Check {
+	check := Check{Name: "RBAC permissions"}
+
+	var missing, missingOptional, unknown []string
+	for _, permission := range a.permissions {
+		allowed, err := a.review(ctx, permission)
+		if err != nil {
+			log.WithError(err).Warnf("failed to review permission: '%s'", permission)
+			unknown = append(unknown, permission.String())
+			continue
+		}
+
+		log.Debugf("permission '%s' in namespace '%s' allowed: %t", permission, a.namespace, allowed)
+
+		switch {
+		case allowed:
+		case permission.Optional:
+			missingOptional = append(missingOptional, permission.String())
+		default:
+			missing = append(missing, permission.String())
+		}
+	}
+
+	var details []string
+	if len(missing) > 0 {
+		details = append(details, fmt.Sprintf("missing in namespace '%s': %s", a.namespace, strings.Join(missing, ", ")))
+	}
+	if len(missingOptional) > 0 {
+		details = append(details, fmt.Sprintf("missing optional in namespace '%s': %s", a.namespace, strings.Join(missingOptional, ", ")))
+	}
+	if len(unknown) > 0 {
+		details = append(details, fmt.Sprintf("couldn't review: %s", strings.Join(unknown, ", ")))
+	}
+
+	switch {
+	case len(missing) > 0:
+		check.Status, check.Detail = FAIL, strings.Join(details, "; ")
+		check.Remediation = "ask a cluster administrator for a role granting the missing permissions"
+	case len(details) > 0:
+		check.Status, check.Detail = WARN, strings.Join(details, "; ")
+	default:
+		check.Status, check.Detail = PASS, fmt.Sprintf("%d permissions granted in namespace '%s'", len(a.permissions), a.namespace)
+	}
+
+	return check
+}
+
+func (a *AccessReviewService) review(ctx context.Context, permission Permission) (bool, error) {
+	namespace := a.namespace
+	if permission.ClusterScoped {
+		namespace = ""
+	}
+
+	review, err := a.clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace:   namespace,
+				Verb:        permission.Verb,
+				Group:       permission.Group,
+				Resource:    permission.Resource,
+				Subresource: permission.Subresource,
+			},
+		},
+	}, v1.CreateOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	return review.Status.Allowed, nil
+}
@@ -0,0 +1,83 @@
+package preflight
+
+import (
+	"debug-me-maybe/pkg/config"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestRequiredPermissions(t *testing.T) {
+	base := []string{
+		"get pods",
+		"create pods/exec",
+		"create pods/portforward",
+		"list pods (optional)",
+		"patch pods (optional)",
+		"get nodes (optional)",
+	}
+	withRequiredList := func(permissions ...string) []string {
+		result := []string{"list pods"}
+		for _, permission := range base {
+			if permission != "list pods (optional)" {
+				result = append(result, permission)
+			}
+		}
+		return append(result, permissions...)
+	}
+
+	tests := []struct {
+		name        string
+		settings    config.DMMSettings
+		permissions []string
+	}{
+		{name: "pod", settings: config.DMMSettings{UserSpecifiedUploadMethod: config.DIRECT}, permissions: base},
+		{name: "selector", settings: config.DMMSettings{UserSpecifiedSelector: "app=operator"},
+			permissions: withRequiredList()},
+		{name: "deployment", settings: config.DMMSettings{UserSpecifiedWorkloadKind: "deployment"},
+			permissions: withRequiredList("get deployments.apps", "list replicasets.apps")},
+		{name: "statefulset", settings: config.DMMSettings{UserSpecifiedWorkloadKind: "statefulset"},
+			permissions: withRequiredList("get statefulsets.apps")},
+		{name: "daemonset", settings: config.DMMSettings{UserSpecifiedWorkloadKind: "daemonset"},
+			permissions: withRequiredList("get daemonsets.apps")},
+		{name: "job", settings: config.DMMSettings{UserSpecifiedWorkloadKind: "job"},
+			permissions: withRequiredList("get jobs.batch")},
+		{name: "disable probes", settings: config.DMMSettings{UserSpecifiedWorkloadKind: "deployment", UserSpecifiedDisableProbes: true},
+			permissions: withRequiredList("get deployments.apps", "list replicasets.apps", "get replicasets.apps", "patch deployments.apps",
+				"get statefulsets.apps", "patch statefulsets.apps", "get daemonsets.apps", "patch daemonsets.apps")},
+		{name: "stager", settings: config.DMMSettings{UserSpecifiedUploadMethod: config.STAGER},
+			permissions: withRequiredList("create pods", "delete pods", "watch pods", "create services", "delete services",
+				"list endpointslices.discovery.k8s.io", "watch endpointslices.discovery.k8s.io",
+				"list networkpolicies.networking.k8s.io (optional)", "list events (optional)")},
+		{name: "stager network policy", settings: config.DMMSettings{UserSpecifiedUploadMethod: config.STAGER, UserSpecifiedStagerPolicy: true},
+			permissions: withRequiredList("create pods", "delete pods", "watch pods", "create services", "delete services",
+				"list endpointslices.discovery.k8s.io", "watch endpointslices.discovery.k8s.io",
+				"list networkpolicies.networking.k8s.io (optional)", "list events (optional)",
+				"create networkpolicies.networking.k8s.io", "delete networkpolicies.networking.k8s.io")},
+		{name: "ephemeral", settings: config.DMMSettings{UserSpecifiedUploadMethod: config.EPHEMERAL},
+			permissions: append(base, "update pods/ephemeralcontainers")},
+		{name: "keep lease", settings: config.DMMSettings{UserSpecifiedKeepLease: true},
+			permissions: append(base, "list leases.coordination.k8s.io", "update leases.coordination.k8s.io")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var permissions []string
+			for _, permission := range RequiredPermissions(&test.settings) {
+				if permission.Optional {
+					permissions = append(permissions, permission.String()+" (optional)")
+				} else {
+					permissions = append(permissions, permission.String())
+				}
+			}
+
+			want := append([]string(nil), test.permissions...)
+			sort.Strings(permissions)
+			sort.Strings(want)
+
+			if !reflect.DeepEqual(permissions, want) {
+				t.Errorf("RequiredPermissions() = %q, want %q", permissions, want)
+			}
+		})
+	}
+}
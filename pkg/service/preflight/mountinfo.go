@@ -0,0 +1,99 @@
+package preflight
+
+import (
+	"path"
+	"strconv"
+	"strings"
+)
+
+type Mount struct {
+	MountPoint string
+	FsType     string
+	Options    []string
+}
+
+// ParseMountInfo parses the content of /proc/<pid>/mountinfo, merging the per
+// mount and the super block options.
+func ParseMountInfo(content string) []Mount {
+	var mounts []Mount
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 10 {
+			continue
+		}
+
+		separator := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				separator = i
+				break
+			}
+		}
+
+		if separator < 0 || separator+3 >= len(fields) {
+			continue
+		}
+
+		options := strings.Split(fields[5], ",")
+		options = append(options, strings.Split(fields[separator+3], ",")...)
+
+		mounts = append(mounts, Mount{
+			MountPoint: unescapeMountPoint(fields[4]),
+			FsType:     fields[separator+1],
+			Options:    options,
+		})
+	}
+
+	return mounts
+}
+
+// unescapeMountPoint decodes the octal escapes (e.g. '\040' for a space) of
+// mountinfo paths.
+func unescapeMountPoint(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+3 < len(value) {
+			if c, err := strconv.ParseUint(value[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(value[i])
+	}
+
+	return b.String()
+}
+
+// FindMount returns the mount the path belongs to, the last mount on the
+// longest matching mount point.
+func FindMount(mounts []Mount, filePath string) (Mount, bool) {
+	filePath = path.Clean(filePath)
+
+	var found Mount
+	var ok bool
+
+	for _, mount := range mounts {
+		mountPoint := path.Clean(mount.MountPoint)
+		if mountPoint != "/" && filePath != mountPoint && !strings.HasPrefix(filePath, mountPoint+"/") {
+			continue
+		}
+
+		if !ok || len(mountPoint) >= len(path.Clean(found.MountPoint)) {
+			found = mount
+			ok = true
+		}
+	}
+
+	return found, ok
+}
+
+func (m Mount) HasOption(option string) bool {
+	for _, o := range m.Options {
+		if o == option {
+			return true
+		}
+	}
+
+	return false
+}
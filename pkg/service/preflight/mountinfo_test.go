@@ -0,0 +1,97 @@
+package preflight
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testMountInfo = `22 1 0:21 / / rw,relatime - overlay overlay rw,lowerdir=/l,upperdir=/u,workdir=/w
+23 22 0:22 / /proc rw,nosuid,nodev,noexec,relatime - proc proc rw
+24 22 0:23 / /tmp rw,nosuid,nodev,noexec,relatime - tmpfs tmpfs rw
+25 22 8:1 /var/lib/kubelet/pods/x/volumes/scratch /scratch rw,relatime master:1 - ext4 /dev/sda1 rw
+26 22 8:1 /etc/hosts /etc/hosts ro,relatime - ext4 /dev/sda1 rw
+27 22 8:1 /data /my\040data rw,relatime - ext4 /dev/sda1 rw
+malformed line
+`
+
+func TestParseMountInfo(t *testing.T) {
+	mounts := ParseMountInfo(testMountInfo)
+
+	tests := []struct {
+		mountPoint string
+		fsType     string
+		options    []string
+	}{
+		{mountPoint: "/", fsType: "overlay", options: []string{"rw", "relatime", "rw", "lowerdir=/l", "upperdir=/u", "workdir=/w"}},
+		{mountPoint: "/proc", fsType: "proc", options: []string{"rw", "nosuid", "nodev", "noexec", "relatime", "rw"}},
+		{mountPoint: "/tmp", fsType: "tmpfs", options: []string{"rw", "nosuid", "nodev", "noexec", "relatime", "rw"}},
+		{mountPoint: "/scratch", fsType: "ext4", options: []string{"rw", "relatime", "rw"}},
+		{mountPoint: "/etc/hosts", fsType: "ext4", options: []string{"ro", "relatime", "rw"}},
+		{mountPoint: "/my data", fsType: "ext4", options: []string{"rw", "relatime", "rw"}},
+	}
+
+	if len(mounts) != len(tests) {
+		t.Fatalf("ParseMountInfo() returned %d mounts, want %d", len(mounts), len(tests))
+	}
+
+	for i, test := range tests {
+		t.Run(test.mountPoint, func(t *testing.T) {
+			mount := mounts[i]
+			if mount.MountPoint != test.mountPoint || mount.FsType != test.fsType || !reflect.DeepEqual(mount.Options, test.options) {
+				t.Errorf("ParseMountInfo() mount %d = %+v, want %s %s %v", i, mount, test.mountPoint, test.fsType, test.options)
+			}
+		})
+	}
+}
+
+func TestFindMount(t *testing.T) {
+	mounts := ParseMountInfo(testMountInfo)
+
+	tests := []struct {
+		path       string
+		mountPoint string
+	}{
+		{path: "/tmp/dlv", mountPoint: "/tmp"},
+		{path: "/tmp", mountPoint: "/tmp"},
+		{path: "/tmp/", mountPoint: "/tmp"},
+		{path: "/tmpfoo/dlv", mountPoint: "/"},
+		{path: "/scratch/dmm/abc/dlv", mountPoint: "/scratch"},
+		{path: "/var/tmp/dlv", mountPoint: "/"},
+		{path: "/my data/dlv", mountPoint: "/my data"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			mount, ok := FindMount(mounts, test.path)
+			if !ok || mount.MountPoint != test.mountPoint {
+				t.Errorf("FindMount(%q) = %q, %t, want %q", test.path, mount.MountPoint, ok, test.mountPoint)
+			}
+		})
+	}
+
+	if _, ok := FindMount(nil, "/tmp/dlv"); ok {
+		t.Errorf("FindMount(nil, %q) found a mount", "/tmp/dlv")
+	}
+}
+
+func TestHasOption(t *testing.T) {
+	mount := Mount{Options: []string{"rw", "noexec"}}
+
+	tests := []struct {
+		option string
+		found  bool
+	}{
+		{option: "noexec", found: true},
+		{option: "rw", found: true},
+		{option: "ro", found: false},
+		{option: "exec", found: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.option, func(t *testing.T) {
+			if found := mount.HasOption(test.option); found != test.found {
+				t.Errorf("HasOption(%q) = %t, want %t", test.option, found, test.found)
+			}
+		})
+	}
+}
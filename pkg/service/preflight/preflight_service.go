@@ -0,0 +1,384 @@
+package preflight
+
+import (
+	"context"
+	"debug-me-maybe/kube"
+	"debug-me-maybe/pkg/arch"
+	"debug-me-maybe/pkg/config"
+	"debug-me-maybe/pkg/service/toolchain"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+type Status string
+
+const (
+	PASS Status = "PASS"
+	WARN Status = "WARN"
+	FAIL Status = "FAIL"
+	SKIP Status = "SKIP"
+)
+
+const capSysPtrace = 19
+
+// factsScript gathers, in a single exec, what the checks need to know about
+// the container. $0 is the remote dlv path, $1 the pid to debug.
+const factsScript = `while read -r key value; do [ "$key" = "CapEff:" ] && echo "capeff=$value"; done < /proc/self/status
+[ -r /proc/sys/kernel/yama/ptrace_scope ] && echo "ptrace_scope=$(cat /proc/sys/kernel/yama/ptrace_scope)"
+echo "arch=$(uname -m 2>/dev/null)"
+for tool in tar curl cat base64 sha256sum; do
+  if command -v $tool >/dev/null 2>&1; then echo "tool.$tool=1"; else echo "tool.$tool=0"; fi
+done
+dir=$(dirname "$0")
+if mkdir -p "$dir" 2>/dev/null && touch "$dir/.dmm-preflight" 2>/dev/null; then rm -f "$dir/.dmm-preflight"; echo writable=1; else echo writable=0; fi
+if [ "$1" != "0" ]; then
+  if [ -d "/proc/$1" ]; then echo pid_exists=1; else echo pid_exists=0; fi
+  if grep -q 'Go buildinf:' "/proc/$1/exe" 2>/dev/null; then echo pid_go=1; else echo pid_go=0; fi
+fi`
+
+type Check struct {
+	Name        string
+	Status      Status
+	Detail      string
+	Remediation string
+}
+
+// PreflightService checks, through the pod spec and commands executed on the
+// container, that the debugger can be uploaded and attached.
+type PreflightService struct {
+	settings             *config.DMMSettings
+	kubernetesApiService kube.KubernetesApiService
+	pod                  *corev1.Pod
+	facts                map[string]string
+	hasShell             bool
+	mounts               []Mount
+}
+
+func NewPreflightService(settings *config.DMMSettings, service kube.KubernetesApiService, pod *corev1.Pod) *PreflightService {
+	return &PreflightService{settings: settings, kubernetesApiService: service, pod: pod}
+}
+
+func (p *PreflightService) Run(ctx context.Context) []Check {
+	p.gatherFacts(ctx)
+
+	return []Check{
+		p.checkShell(),
+		p.checkPtraceCapability(),
+		p.checkPtraceScope(),
+		p.checkUploadTools(),
+		p.checkRemotePath(),
+		p.checkArchitecture(),
+		p.checkToolchain(),
+		p.checkPid(),
+	}
+}
+
+func (p *PreflightService) gatherFacts(ctx context.Context) {
+	p.facts = map[string]string{}
+
+	output := new(kube.Writer)
+	command := []string{"/bin/sh", "-c", factsScript, p.settings.DetectedRemoteDlvPath, strconv.Itoa(p.settings.UserSpecifiedPid)}
+
+	exitCode, err := p.kubernetesApiService.ExecuteCommand(ctx, p.settings.UserSpecifiedPodName, p.settings.UserSpecifiedContainer, command, output)
+	if err == nil && exitCode == 0 {
+		p.hasShell = true
+
+		for _, line := range strings.Split(output.Output, "\n") {
+			if key, value, found := strings.Cut(line, "="); found {
+				p.facts[key] = strings.TrimSpace(value)
+			}
+		}
+	}
+
+	mountInfo := new(kube.Writer)
+	command = []string{"cat", "/proc/self/mountinfo"}
+
+	exitCode, err = p.kubernetesApiService.ExecuteCommand(ctx, p.settings.UserSpecifiedPodName, p.settings.UserSpecifiedContainer, command, mountInfo)
+	if err == nil && exitCode == 0 {
+		p.mounts = ParseMountInfo(mountInfo.Output)
+	}
+}
+
+func (p *PreflightService) container() *corev1.Container {
+	for i := range p.pod.Spec.Containers {
+		if p.pod.Spec.Containers[i].Name == p.settings.UserSpecifiedContainer {
+			return &p.pod.Spec.Containers[i]
+		}
+	}
+
+	return nil
+}
+
+func (p *PreflightService) isEphemeral() bool {
+	return p.settings.UserSpecifiedUploadMethod == config.EPHEMERAL
+}
+
+func (p *PreflightService) checkShell() Check {
+	check := Check{Name: "shell"}
+
+	switch {
+	case p.hasShell:
+		check.Status, check.Detail = PASS, "/bin/sh is available"
+	case p.isEphemeral():
+		check.Status, check.Detail = SKIP, "/bin/sh is not available, the ephemeral debugger container provides it"
+	default:
+		check.Status, check.Detail = FAIL, "/bin/sh is not available, the debugger is launched through it"
+		check.Remediation = "use '--upload-method ephemeral' for images without a shell"
+	}
+
+	return check
+}
+
+func (p *PreflightService) checkPtraceCapability() Check {
+	check := Check{Name: "SYS_PTRACE capability"}
+
+	if p.isEphemeral() {
+		check.Status, check.Detail = SKIP, "the ephemeral debugger container is granted SYS_PTRACE"
+		return check
+	}
+
+	if container := p.container(); container != nil && container.SecurityContext != nil {
+		securityContext := container.SecurityContext
+		if securityContext.Privileged != nil && *securityContext.Privileged {
+			check.Status, check.Detail = PASS, "container is privileged"
+			return check
+		}
+
+		if securityContext.Capabilities != nil {
+			for _, capability := range securityContext.Capabilities.Add {
+				if capability == "SYS_PTRACE" || capability == "CAP_SYS_PTRACE" {
+					check.Status, check.Detail = PASS, "SYS_PTRACE is added to the container's capabilities"
+					return check
+				}
+			}
+		}
+	}
+
+	if capEff, ok := p.facts["capeff"]; ok {
+		if caps, err := strconv.ParseUint(capEff, 16, 64); err == nil && caps&(1<<capSysPtrace) != 0 {
+			check.Status, check.Detail = PASS, "SYS_PTRACE is in the container's effective capabilities"
+			return check
+		}
+	}
+
+	check.Status, check.Detail = WARN, "SYS_PTRACE isn't granted to the container, attaching only works with a ptrace_scope of 0"
+	check.Remediation = "add SYS_PTRACE to the container's securityContext.capabilities, or use '--upload-method ephemeral'"
+
+	if scope := p.facts["ptrace_scope"]; scope != "" && scope != "0" {
+		check.Status = FAIL
+	}
+
+	return check
+}
+
+func (p *PreflightService) checkPtraceScope() Check {
+	check := Check{Name: "kernel.yama.ptrace_scope"}
+
+	scope, ok := p.facts["ptrace_scope"]
+	switch {
+	case !p.hasShell:
+		check.Status, check.Detail = WARN, "unknown, the container has no shell"
+	case !ok:
+		check.Status, check.Detail = PASS, "Yama is not enabled"
+	case scope == "0" || scope == "1" || scope == "2":
+		check.Status, check.Detail = PASS, fmt.Sprintf("%s, attaching requires SYS_PTRACE unless it is 0", scope)
+	default:
+		check.Status, check.Detail = FAIL, fmt.Sprintf("%s, attaching to processes is disabled on the node", scope)
+		check.Remediation = "set kernel.yama.ptrace_scope to 2 or less on the node"
+	}
+
+	return check
+}
+
+func (p *PreflightService) hasTool(tool string) bool {
+	return p.facts["tool."+tool] == "1"
+}
+
+func (p *PreflightService) checkUploadTools() Check {
+	check := Check{Name: "upload tools"}
+
+	var available, missing []string
+	for _, tool := range []string{"tar", "curl", "cat", "dd", "base64", "sha256sum"} {
+		if p.hasTool(tool) {
+			available = append(available, tool)
+		} else {
+			missing = append(missing, tool)
+		}
+	}
+
+	check.Detail = fmt.Sprintf("available: [%s], missing: [%s]", strings.Join(available, " "), strings.Join(missing, " "))
+
+	if !p.hasShell {
+		check.Detail = "unknown, the container has no shell"
+	}
+
+	var satisfied bool
+	switch p.settings.UserSpecifiedUploadMethod {
+	case config.DIRECT:
+		satisfied = p.hasTool("tar")
+		check.Remediation = "'direct' requires tar, try '--upload-method chunked'"
+	case config.STAGER:
+		satisfied = p.hasTool("curl")
+		check.Remediation = "'stager' requires curl, try '--upload-method chunked'"
+	case config.CHUNKED:
+		satisfied = p.hasTool("cat") || p.hasTool("dd") || p.hasTool("base64")
+		check.Remediation = "'chunked' requires cat, dd or base64, try '--upload-method ephemeral'"
+	case config.EPHEMERAL:
+		check.Status, check.Remediation = SKIP, ""
+		check.Detail += ", the ephemeral debugger container provides the upload tools"
+		return check
+	}
+
+	if satisfied {
+		check.Status, check.Remediation = PASS, ""
+	} else {
+		check.Status = FAIL
+	}
+
+	return check
+}
+
+func (p *PreflightService) checkRemotePath() Check {
+	directory := path.Dir(p.settings.DetectedRemoteDlvPath)
+	check := Check{Name: "remote path"}
+
+	if p.isEphemeral() {
+		check.Status, check.Detail = SKIP, fmt.Sprintf("'%s' is on the ephemeral debugger container", directory)
+		return check
+	}
+
+	if mount, ok := FindMount(p.mounts, directory); ok {
+		if mount.HasOption("ro") {
+			check.Status, check.Detail = FAIL, fmt.Sprintf("'%s' is on read-only mount '%s'", directory, mount.MountPoint)
+			check.Remediation = "use '--remote-dlv-dir' on a writable mount, e.g. an emptyDir"
+			return check
+		}
+
+		if mount.HasOption("noexec") {
+			check.Status, check.Detail = FAIL, fmt.Sprintf("'%s' is on noexec mount '%s'", directory, mount.MountPoint)
+			check.Remediation = "use '--remote-dlv-dir' on a mount allowing execution"
+			return check
+		}
+	}
+
+	switch {
+	case p.facts["writable"] == "1":
+		check.Status, check.Detail = PASS, fmt.Sprintf("'%s' is writable", directory)
+	case !p.hasShell:
+		check.Status, check.Detail = WARN, fmt.Sprintf("unknown whether '%s' is writable, the container has no shell", directory)
+	default:
+		check.Status, check.Detail = FAIL, fmt.Sprintf("'%s' is not writable", directory)
+		check.Remediation = "use '--remote-dlv-dir' on a writable mount, e.g. an emptyDir"
+	}
+
+	return check
+}
+
+func (p *PreflightService) checkArchitecture() Check {
+	check := Check{Name: "architecture"}
+
+	localArch, err := arch.FromELF(p.settings.UserSpecifiedLocalDlvPath)
+	if err != nil {
+		check.Status, check.Detail = WARN, err.Error()
+		return check
+	}
+
+	remoteArch, err := arch.FromUname(p.facts["arch"])
+	if err != nil && p.settings.DetectedArchitecture != "" {
+		remoteArch, err = p.settings.DetectedArchitecture, nil
+	}
+	if err != nil {
+		check.Status, check.Detail = WARN, fmt.Sprintf("unknown container architecture, local dlv is %s", localArch)
+		return check
+	}
+
+	if localArch != remoteArch {
+		check.Status, check.Detail = FAIL, fmt.Sprintf("container is %s, local dlv is %s", remoteArch, localArch)
+		check.Remediation = fmt.Sprintf("build dlv with GOARCH=%s and use '--local-dlv-path'", remoteArch)
+		return check
+	}
+
+	check.Status, check.Detail = PASS, remoteArch
+
+	return check
+}
+
+func (p *PreflightService) checkToolchain() Check {
+	check := Check{Name: "Go toolchain"}
+
+	goVersion, delveVersion := p.settings.DetectedGoVersion, p.settings.DetectedDelveVersion
+
+	switch {
+	case goVersion == "":
+		check.Status, check.Detail = WARN, "unknown Go version of the process to debug"
+	case delveVersion == "":
+		check.Status, check.Detail = WARN, fmt.Sprintf("%s, unknown Delve version of the local dlv", goVersion)
+	case !toolchain.Supports(delveVersion, goVersion):
+		check.Status, check.Detail = FAIL, fmt.Sprintf("%s, not supported by dlv %s", goVersion, toolchain.DescribeSupport(delveVersion))
+		check.Remediation = fmt.Sprintf("build dlv with a Delve release supporting %s and name it 'dlv-<version>-linux-<arch>'", goVersion)
+	default:
+		check.Status, check.Detail = PASS, fmt.Sprintf("%s, supported by dlv %s", goVersion, toolchain.DescribeSupport(delveVersion))
+	}
+
+	return check
+}
+
+func (p *PreflightService) checkPid() Check {
+	check := Check{Name: "target pid"}
+
+	switch {
+	case p.settings.UserSpecifiedPid == 0:
+		check.Status, check.Detail = SKIP, "no pid to debug"
+	case !p.hasShell:
+		check.Status, check.Detail = WARN, fmt.Sprintf("unknown whether pid %d exists, the container has no shell", p.settings.UserSpecifiedPid)
+	case p.facts["pid_exists"] != "1":
+		check.Status, check.Detail = FAIL, fmt.Sprintf("pid %d doesn't exist", p.settings.UserSpecifiedPid)
+		check.Remediation = "use '--process-name' or '--exe' to find the process to debug"
+	case p.facts["pid_go"] != "1":
+		check.Status, check.Detail = WARN, fmt.Sprintf("pid %d doesn't look like a Go binary (no Go build info found)", p.settings.UserSpecifiedPid)
+		check.Remediation = "dlv only debugs Go programs, check the pid"
+	default:
+		check.Status, check.Detail = PASS, fmt.Sprintf("pid %d is a Go binary", p.settings.UserSpecifiedPid)
+	}
+
+	return check
+}
+
+func HasFailures(checks []Check) bool {
+	for _, check := range checks {
+		if check.Status == FAIL {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PrintChecks writes the checks as a table, followed by the remediation hints
+// of the ones that didn't pass.
+func PrintChecks(out io.Writer, checks []Check) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "CHECK\tSTATUS\tDETAIL")
+
+	for _, check := range checks {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", check.Name, check.Status, check.Detail)
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	for _, check := range checks {
+		if check.Remediation != "" && (check.Status == FAIL || check.Status == WARN) {
+			_, _ = fmt.Fprintf(out, "* %s: %s\n", check.Name, check.Remediation)
+		}
+	}
+
+	return nil
+}
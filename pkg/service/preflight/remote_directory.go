@@ -0,0 +1,136 @@
+package preflight
+
+import (
+	"context"
+	"debug-me-maybe/kube"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// probeDirectoriesScript prints, for each directory given as argument, whether
+// a file can be created in it.
+const probeDirectoriesScript = `for d in "$@"; do
+  if mkdir -p "$d" 2>/dev/null && touch "$d/.dmm-probe" 2>/dev/null; then
+    rm -f "$d/.dmm-probe"
+    printf '%s\t1\n' "$d"
+  else
+    printf '%s\t0\n' "$d"
+  fi
+done`
+
+// ignoredMountPrefixes are the mount points never considered to hold dlv.
+var ignoredMountPrefixes = []string{"/proc", "/sys", "/dev", "/run/secrets", "/var/run/secrets", "/etc"}
+
+// RemoteDirectory is a directory of the container considered to hold dlv, with
+// the reason it was rejected when it can't.
+type RemoteDirectory struct {
+	Path       string
+	Discovered bool
+	Rejection  string
+}
+
+// RemoteDirectoryService picks the directory of the container to upload dlv
+// to: it must be writable and on a mount allowing execution, which hardened
+// pods (read-only root filesystem, noexec /tmp) make hard to guess.
+type RemoteDirectoryService struct {
+	kubernetesApiService kube.KubernetesApiService
+	podName              string
+	containerName        string
+}
+
+func NewRemoteDirectoryService(service kube.KubernetesApiService, podName string, containerName string) *RemoteDirectoryService {
+	return &RemoteDirectoryService{kubernetesApiService: service, podName: podName, containerName: containerName}
+}
+
+// Probe checks the candidate directories, then the writable mounts allowing
+// execution found in /proc/self/mountinfo, in that order. The first one
+// without a rejection is the one to use.
+func (r *RemoteDirectoryService) Probe(ctx context.Context, candidates []string) ([]RemoteDirectory, error) {
+	mountInfo := new(kube.Writer)
+
+	exitCode, err := r.kubernetesApiService.ExecuteCommand(ctx, r.podName, r.containerName, []string{"cat", "/proc/self/mountinfo"}, mountInfo)
+	if err != nil || exitCode != 0 {
+		return nil, errors.Errorf("failed to read the mounts of container: '%s', exit code: '%d'", r.containerName, exitCode)
+	}
+
+	mounts := ParseMountInfo(mountInfo.Output)
+
+	var directories []RemoteDirectory
+	seen := map[string]bool{}
+
+	for _, candidate := range candidates {
+		if !seen[candidate] {
+			seen[candidate] = true
+			directories = append(directories, RemoteDirectory{Path: candidate})
+		}
+	}
+
+	for _, mount := range mounts {
+		if seen[mount.MountPoint] || isIgnoredMount(mount) || mount.HasOption("ro") || mount.HasOption("noexec") {
+			continue
+		}
+
+		seen[mount.MountPoint] = true
+		directories = append(directories, RemoteDirectory{Path: mount.MountPoint, Discovered: true})
+	}
+
+	for i := range directories {
+		if mount, ok := FindMount(mounts, directories[i].Path); ok {
+			if mount.HasOption("ro") {
+				directories[i].Rejection = fmt.Sprintf("on read-only mount '%s'", mount.MountPoint)
+			} else if mount.HasOption("noexec") {
+				directories[i].Rejection = fmt.Sprintf("on noexec mount '%s'", mount.MountPoint)
+			}
+		}
+	}
+
+	var writable []string
+	for _, directory := range directories {
+		if directory.Rejection == "" {
+			writable = append(writable, directory.Path)
+		}
+	}
+
+	if len(writable) == 0 {
+		return directories, nil
+	}
+
+	probe := new(kube.Writer)
+	command := append([]string{"/bin/sh", "-c", probeDirectoriesScript, "sh"}, writable...)
+
+	exitCode, err = r.kubernetesApiService.ExecuteCommand(ctx, r.podName, r.containerName, command, probe)
+	if err != nil || exitCode != 0 {
+		return nil, errors.Errorf("failed to probe directories on container: '%s', exit code: '%d'", r.containerName, exitCode)
+	}
+
+	results := map[string]bool{}
+	for _, line := range strings.Split(probe.Output, "\n") {
+		if directory, result, found := strings.Cut(line, "\t"); found {
+			results[directory] = result == "1"
+		}
+	}
+
+	for i := range directories {
+		if directories[i].Rejection == "" && !results[directories[i].Path] {
+			directories[i].Rejection = "not writable"
+		}
+	}
+
+	return directories, nil
+}
+
+func isIgnoredMount(mount Mount) bool {
+	if mount.MountPoint == "/" {
+		return true
+	}
+
+	for _, prefix := range ignoredMountPrefixes {
+		if mount.MountPoint == prefix || strings.HasPrefix(mount.MountPoint, prefix+"/") {
+			return true
+		}
+	}
+
+	return false
+}
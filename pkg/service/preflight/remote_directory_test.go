@@ -0,0 +1,86 @@
+package preflight
+
+import (
+	"context"
+	"debug-me-maybe/kube"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// fakeApiService answers the commands of the remote directory probe: the
+// mountinfo, and whether each directory is writable.
+type fakeApiService struct {
+	kube.KubernetesApiService
+	mountInfo string
+	writable  map[string]bool
+}
+
+func (f *fakeApiService) ExecuteCommand(ctx context.Context, podName string, containerName string, command []string, stdOut io.Writer) (int, error) {
+	if len(command) == 2 && command[0] == "cat" && command[1] == "/proc/self/mountinfo" {
+		_, err := io.WriteString(stdOut, f.mountInfo)
+		return 0, err
+	}
+
+	if len(command) > 4 && command[2] == probeDirectoriesScript {
+		var output strings.Builder
+		for _, directory := range command[4:] {
+			result := 0
+			if f.writable[directory] {
+				result = 1
+			}
+			_, _ = fmt.Fprintf(&output, "%s\t%d\n", directory, result)
+		}
+
+		_, err := io.WriteString(stdOut, output.String())
+		return 0, err
+	}
+
+	return 1, fmt.Errorf("unexpected command: %v", command)
+}
+
+func TestRemoteDirectoryServiceProbe(t *testing.T) {
+	service := &fakeApiService{
+		mountInfo: `22 1 0:21 / / ro,relatime - overlay overlay rw
+23 22 0:22 / /proc rw,nosuid,nodev,noexec,relatime - proc proc rw
+24 22 0:23 / /tmp rw,nosuid,nodev,noexec,relatime - tmpfs tmpfs rw
+25 22 8:1 /volumes/scratch /scratch rw,relatime - ext4 /dev/sda1 rw
+26 22 8:1 /volumes/cache /cache rw,relatime - ext4 /dev/sda1 rw
+27 22 8:1 /volumes/config /config ro,relatime - ext4 /dev/sda1 rw
+28 22 8:1 /etc/hosts /etc/hosts rw,relatime - ext4 /dev/sda1 rw
+29 22 0:24 / /run/secrets/kubernetes.io/serviceaccount rw,relatime - tmpfs tmpfs rw
+`,
+		writable: map[string]bool{"/scratch": true, "/cache": false},
+	}
+
+	directories, err := NewRemoteDirectoryService(service, "pod", "container").Probe(context.Background(), []string{"/tmp", "/var/tmp", "/tmp"})
+	if err != nil {
+		t.Fatalf("Probe() failed: %s", err)
+	}
+
+	tests := []struct {
+		path       string
+		discovered bool
+		rejection  string
+	}{
+		{path: "/tmp", rejection: "on noexec mount '/tmp'"},
+		{path: "/var/tmp", rejection: "on read-only mount '/'"},
+		{path: "/scratch", discovered: true},
+		{path: "/cache", discovered: true, rejection: "not writable"},
+	}
+
+	if len(directories) != len(tests) {
+		t.Fatalf("Probe() returned %+v, want %d directories", directories, len(tests))
+	}
+
+	for i, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			directory := directories[i]
+			if directory.Path != test.path || directory.Discovered != test.discovered || directory.Rejection != test.rejection {
+				t.Errorf("Probe() directory %d = %+v, want %s discovered: %t, rejection: %q",
+					i, directory, test.path, test.discovered, test.rejection)
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// originalProbeAnnotation records the liveness probe removed from a workload,
+// so that it can be restored even by another dmm invocation.
+const originalProbeAnnotation = "debug-me-maybe/original-liveness-probe"
+
+// ErrNoWorkload is returned by FindOwner when the pod isn't owned by a
+// workload whose pod template can be patched, e.g. a bare or job pod.
+var ErrNoWorkload = errors.New("its probes can't be neutralised")
+
+const rolloutTimeout = 5 * time.Minute
+const rolloutPollInterval = 2 * time.Second
+
+const (
+	DeploymentKind  = "Deployment"
+	StatefulSetKind = "StatefulSet"
+	DaemonSetKind   = "DaemonSet"
+)
+
+// Budget returns how long the process may be halted before the kubelet
+// restarts the container because of its liveness probe, and whether there is
+// a liveness probe at all. The last failing probe only fails once it timed out.
+func Budget(container *corev1.Container) (time.Duration, bool) {
+	probe := container.LivenessProbe
+	if probe == nil {
+		return 0, false
+	}
+
+	periodSeconds := probe.PeriodSeconds
+	if periodSeconds == 0 {
+		periodSeconds = 10
+	}
+
+	failureThreshold := probe.FailureThreshold
+	if failureThreshold == 0 {
+		failureThreshold = 3
+	}
+
+	timeoutSeconds := probe.TimeoutSeconds
+	if timeoutSeconds == 0 {
+		timeoutSeconds = 1
+	}
+
+	return time.Duration(periodSeconds*failureThreshold+timeoutSeconds) * time.Second, true
+}
+
+// ProbeGuardService neutralises the liveness probe of a container for the
+// duration of a debug session by patching the workload owning the pod. The
+// startup probe is left in place, it only matters until the container started.
+type ProbeGuardService struct {
+	clientset     kubernetes.Interface
+	namespace     string
+	containerName string
+	ownerKind     string
+	ownerName     string
+}
+
+func NewProbeGuardService(clientset kubernetes.Interface, namespace string, containerName string) *ProbeGuardService {
+	return &ProbeGuardService{clientset: clientset, namespace: namespace, containerName: containerName}
+}
+
+// FindOwner resolves the Deployment, StatefulSet or DaemonSet owning the pod.
+func (p *ProbeGuardService) FindOwner(ctx context.Context, pod *corev1.Pod) error {
+	owner := v1.GetControllerOf(pod)
+	if owner == nil {
+		return errors.Wrapf(ErrNoWorkload, "pod '%s' isn't owned by a workload", pod.Name)
+	}
+
+	switch owner.Kind {
+	case "ReplicaSet":
+		replicaSet, err := p.clientset.AppsV1().ReplicaSets(p.namespace).Get(ctx, owner.Name, v1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		deployment := v1.GetControllerOf(replicaSet)
+		if deployment == nil || deployment.Kind != DeploymentKind {
+			return errors.Wrapf(ErrNoWorkload, "replicaset '%s' isn't owned by a deployment", owner.Name)
+		}
+
+		p.ownerKind, p.ownerName = DeploymentKind, deployment.Name
+	case StatefulSetKind, DaemonSetKind:
+		p.ownerKind, p.ownerName = owner.Kind, owner.Name
+	default:
+		return errors.Wrapf(ErrNoWorkload, "pod '%s' is owned by a %s", pod.Name, owner.Kind)
+	}
+
+	log.Debugf("pod '%s' is owned by %s '%s'", pod.Name, p.ownerKind, p.ownerName)
+
+	return nil
+}
+
+func (p *ProbeGuardService) Owner() (string, string) {
+	return p.ownerKind, p.ownerName
+}
+
+func (p *ProbeGuardService) Namespace() string {
+	return p.namespace
+}
+
+// Disable removes the liveness probe of the container from the workload and
+// waits for the resulting rollout, the debugged pod is replaced.
+func (p *ProbeGuardService) Disable(ctx context.Context, probe *corev1.Probe) error {
+	original, err := json.Marshal(probe)
+	if err != nil {
+		return err
+	}
+
+	originalValue := string(original)
+
+	log.Infof("removing the liveness probe of container: '%s' from %s '%s'", p.containerName, p.ownerKind, p.ownerName)
+
+	if err := p.patch(ctx, nil, &originalValue); err != nil {
+		return err
+	}
+
+	return p.waitForRollout(ctx)
+}
+
+// Disabled tells whether the liveness probe recorded on the workload was
+// removed by a session.
+func (p *ProbeGuardService) Disabled(ctx context.Context) (bool, error) {
+	original, err := p.originalProbe(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return original != "", nil
+}
+
+// Restore puts back the liveness probe recorded on the workload.
+func (p *ProbeGuardService) Restore(ctx context.Context) error {
+	original, err := p.originalProbe(ctx)
+	if err != nil {
+		return err
+	}
+
+	if original == "" {
+		log.Debugf("no liveness probe to restore on %s '%s'", p.ownerKind, p.ownerName)
+		return nil
+	}
+
+	probe := &corev1.Probe{}
+	if err := json.Unmarshal([]byte(original), probe); err != nil {
+		return errors.Wrapf(err, "invalid '%s' annotation on %s '%s'", originalProbeAnnotation, p.ownerKind, p.ownerName)
+	}
+
+	log.Infof("restoring the liveness probe of container: '%s' on %s '%s'", p.containerName, p.ownerKind, p.ownerName)
+
+	return p.patch(ctx, probe, nil)
+}
+
+// patch sets the liveness probe of the container, and the annotation
+// recording the original probe, nil values removing them.
+func (p *ProbeGuardService) patch(ctx context.Context, probe *corev1.Probe, original *string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]*string{
+				originalProbeAnnotation: original,
+			},
+		},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []map[string]interface{}{
+						{
+							"name":          p.containerName,
+							"livenessProbe": probe,
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	switch p.ownerKind {
+	case DeploymentKind:
+		_, err = p.clientset.AppsV1().Deployments(p.namespace).Patch(ctx, p.ownerName, types.StrategicMergePatchType, patch, v1.PatchOptions{})
+	case StatefulSetKind:
+		_, err = p.clientset.AppsV1().StatefulSets(p.namespace).Patch(ctx, p.ownerName, types.StrategicMergePatchType, patch, v1.PatchOptions{})
+	case DaemonSetKind:
+		_, err = p.clientset.AppsV1().DaemonSets(p.namespace).Patch(ctx, p.ownerName, types.StrategicMergePatchType, patch, v1.PatchOptions{})
+	default:
+		err = errors.Errorf("unsupported workload kind: '%s'", p.ownerKind)
+	}
+
+	return err
+}
+
+func (p *ProbeGuardService) originalProbe(ctx context.Context) (string, error) {
+	var meta v1.ObjectMeta
+
+	switch p.ownerKind {
+	case DeploymentKind:
+		deployment, err := p.clientset.AppsV1().Deployments(p.namespace).Get(ctx, p.ownerName, v1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		meta = deployment.ObjectMeta
+	case StatefulSetKind:
+		statefulSet, err := p.clientset.AppsV1().StatefulSets(p.namespace).Get(ctx, p.ownerName, v1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		meta = statefulSet.ObjectMeta
+	case DaemonSetKind:
+		daemonSet, err := p.clientset.AppsV1().DaemonSets(p.namespace).Get(ctx, p.ownerName, v1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		meta = daemonSet.ObjectMeta
+	default:
+		return "", errors.Errorf("unsupported workload kind: '%s'", p.ownerKind)
+	}
+
+	return meta.Annotations[originalProbeAnnotation], nil
+}
+
+func (p *ProbeGuardService) waitForRollout(ctx context.Context) error {
+	log.Infof("waiting for the rollout of %s '%s'", p.ownerKind, p.ownerName)
+
+	deadline := time.After(rolloutTimeout)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			return errors.Errorf("rollout of %s '%s' didn't complete within %s", p.ownerKind, p.ownerName, rolloutTimeout)
+		case <-time.After(rolloutPollInterval):
+		}
+
+		done, status, err := p.rolloutStatus(ctx)
+		if err != nil {
+			return err
+		}
+
+		if done {
+			log.Infof("rollout of %s '%s' complete", p.ownerKind, p.ownerName)
+			return nil
+		}
+
+		log.Debugf("rollout of %s '%s' in progress: %s", p.ownerKind, p.ownerName, status)
+	}
+}
+
+func (p *ProbeGuardService) rolloutStatus(ctx context.Context) (bool, string, error) {
+	switch p.ownerKind {
+	case DeploymentKind:
+		d, err := p.clientset.AppsV1().Deployments(p.namespace).Get(ctx, p.ownerName, v1.GetOptions{})
+		if err != nil {
+			return false, "", err
+		}
+
+		replicas := int32(1)
+		if d.Spec.Replicas != nil {
+			replicas = *d.Spec.Replicas
+		}
+
+		done := d.Status.ObservedGeneration >= d.Generation && d.Status.UpdatedReplicas == replicas &&
+			d.Status.Replicas == replicas && d.Status.AvailableReplicas == replicas
+
+		return done, fmt.Sprintf("%d/%d updated, %d available", d.Status.UpdatedReplicas, replicas, d.Status.AvailableReplicas), nil
+	case StatefulSetKind:
+		s, err := p.clientset.AppsV1().StatefulSets(p.namespace).Get(ctx, p.ownerName, v1.GetOptions{})
+		if err != nil {
+			return false, "", err
+		}
+
+		replicas := int32(1)
+		if s.Spec.Replicas != nil {
+			replicas = *s.Spec.Replicas
+		}
+
+		done := s.Status.ObservedGeneration >= s.Generation && s.Status.UpdateRevision == s.Status.CurrentRevision &&
+			s.Status.UpdatedReplicas == replicas && s.Status.ReadyReplicas == replicas
+
+		return done, fmt.Sprintf("%d/%d updated, %d ready", s.Status.UpdatedReplicas, replicas, s.Status.ReadyReplicas), nil
+	case DaemonSetKind:
+		d, err := p.clientset.AppsV1().DaemonSets(p.namespace).Get(ctx, p.ownerName, v1.GetOptions{})
+		if err != nil {
+			return false, "", err
+		}
+
+		done := d.Status.ObservedGeneration >= d.Generation && d.Status.UpdatedNumberScheduled == d.Status.DesiredNumberScheduled &&
+			d.Status.NumberReady == d.Status.DesiredNumberScheduled
+
+		return done, fmt.Sprintf("%d/%d updated, %d ready", d.Status.UpdatedNumberScheduled, d.Status.DesiredNumberScheduled, d.Status.NumberReady), nil
+	default:
+		return false, "", errors.Errorf("unsupported workload kind: '%s'", p.ownerKind)
+	}
+}
@@ -0,0 +1,193 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestBudget(t *testing.T) {
+	tests := []struct {
+		name   string
+		probe  *corev1.Probe
+		budget time.Duration
+		found  bool
+	}{
+		{name: "no probe", found: false},
+		{name: "defaults", probe: &corev1.Probe{}, budget: 31 * time.Second, found: true},
+		{name: "period and threshold", probe: &corev1.Probe{PeriodSeconds: 5, FailureThreshold: 2}, budget: 11 * time.Second, found: true},
+		{name: "timeout", probe: &corev1.Probe{PeriodSeconds: 20, FailureThreshold: 3, TimeoutSeconds: 15}, budget: 75 * time.Second, found: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			budget, found := Budget(&corev1.Container{LivenessProbe: test.probe})
+			if budget != test.budget || found != test.found {
+				t.Errorf("Budget() = %s, %t, want %s, %t", budget, found, test.budget, test.found)
+			}
+		})
+	}
+}
+
+func controllerReference(kind string, name string, uid string) []v1.OwnerReference {
+	controller := true
+	return []v1.OwnerReference{{Kind: kind, Name: name, UID: types.UID("uid-" + uid), Controller: &controller}}
+}
+
+func TestDisableRestore(t *testing.T) {
+	liveness := &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{Path: "/healthz", Port: intstr.FromInt(8081)},
+		},
+		PeriodSeconds: 20,
+	}
+	sidecarLiveness := &corev1.Probe{PeriodSeconds: 5}
+
+	replicas := int32(1)
+	template := corev1.PodTemplateSpec{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "manager", LivenessProbe: liveness},
+				{Name: "proxy", LivenessProbe: sidecarLiveness},
+			},
+		},
+	}
+	meta := v1.ObjectMeta{Name: "operator", Namespace: "system", UID: "uid-operator"}
+
+	tests := []struct {
+		name      string
+		kind      string
+		objects   []runtime.Object
+		owner     []v1.OwnerReference
+		templates func(kubernetes.Interface) corev1.PodTemplateSpec
+	}{
+		{
+			name: "deployment",
+			kind: DeploymentKind,
+			objects: []runtime.Object{
+				&appsv1.Deployment{ObjectMeta: meta, Spec: appsv1.DeploymentSpec{Replicas: &replicas, Template: template},
+					Status: appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}},
+				&appsv1.ReplicaSet{ObjectMeta: v1.ObjectMeta{Name: "operator-7d9f", Namespace: "system",
+					OwnerReferences: controllerReference(DeploymentKind, "operator", "operator")}},
+			},
+			owner: controllerReference("ReplicaSet", "operator-7d9f", "operator-7d9f"),
+			templates: func(c kubernetes.Interface) corev1.PodTemplateSpec {
+				d, _ := c.AppsV1().Deployments("system").Get(context.Background(), "operator", v1.GetOptions{})
+				return d.Spec.Template
+			},
+		},
+		{
+			name: "statefulset",
+			kind: StatefulSetKind,
+			objects: []runtime.Object{
+				&appsv1.StatefulSet{ObjectMeta: meta, Spec: appsv1.StatefulSetSpec{Replicas: &replicas, Template: template},
+					Status: appsv1.StatefulSetStatus{UpdatedReplicas: 1, ReadyReplicas: 1}},
+			},
+			owner: controllerReference(StatefulSetKind, "operator", "operator"),
+			templates: func(c kubernetes.Interface) corev1.PodTemplateSpec {
+				s, _ := c.AppsV1().StatefulSets("system").Get(context.Background(), "operator", v1.GetOptions{})
+				return s.Spec.Template
+			},
+		},
+		{
+			name: "daemonset",
+			kind: DaemonSetKind,
+			objects: []runtime.Object{
+				&appsv1.DaemonSet{ObjectMeta: meta, Spec: appsv1.DaemonSetSpec{Template: template},
+					Status: appsv1.DaemonSetStatus{DesiredNumberScheduled: 2, UpdatedNumberScheduled: 2, NumberReady: 2}},
+			},
+			owner: controllerReference(DaemonSetKind, "operator", "operator"),
+			templates: func(c kubernetes.Interface) corev1.PodTemplateSpec {
+				d, _ := c.AppsV1().DaemonSets("system").Get(context.Background(), "operator", v1.GetOptions{})
+				return d.Spec.Template
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			clientset := fake.NewSimpleClientset(test.objects...)
+
+			p := NewProbeGuardService(clientset, "system", "manager")
+
+			pod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "operator-0", Namespace: "system", OwnerReferences: test.owner}}
+			if err := p.FindOwner(ctx, pod); err != nil {
+				t.Fatalf("FindOwner() = %v", err)
+			}
+
+			if kind, name := p.Owner(); kind != test.kind || name != "operator" {
+				t.Fatalf("Owner() = %q, %q, want %q, %q", kind, name, test.kind, "operator")
+			}
+
+			if err := p.Disable(ctx, liveness); err != nil {
+				t.Fatalf("Disable() = %v", err)
+			}
+
+			disabled := test.templates(clientset)
+			if probe := disabled.Spec.Containers[0].LivenessProbe; probe != nil {
+				t.Errorf("liveness probe of 'manager' after Disable() = %v, want none", probe)
+			}
+			if probe := disabled.Spec.Containers[1].LivenessProbe; !reflect.DeepEqual(probe, sidecarLiveness) {
+				t.Errorf("liveness probe of 'proxy' after Disable() = %v, want %v", probe, sidecarLiveness)
+			}
+
+			if found, err := p.Disabled(ctx); err != nil || !found {
+				t.Errorf("Disabled() after Disable() = %t, %v, want true", found, err)
+			}
+
+			if err := p.Restore(ctx); err != nil {
+				t.Fatalf("Restore() = %v", err)
+			}
+
+			restored := test.templates(clientset)
+			if probe := restored.Spec.Containers[0].LivenessProbe; !reflect.DeepEqual(probe, liveness) {
+				t.Errorf("liveness probe of 'manager' after Restore() = %v, want %v", probe, liveness)
+			}
+			if probe := restored.Spec.Containers[1].LivenessProbe; !reflect.DeepEqual(probe, sidecarLiveness) {
+				t.Errorf("liveness probe of 'proxy' after Restore() = %v, want %v", probe, sidecarLiveness)
+			}
+
+			if found, err := p.Disabled(ctx); err != nil || found {
+				t.Errorf("Disabled() after Restore() = %t, %v, want false", found, err)
+			}
+		})
+	}
+}
+
+func TestFindOwnerWithoutWorkload(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&appsv1.ReplicaSet{ObjectMeta: v1.ObjectMeta{Name: "bare-rs", Namespace: "system"}},
+	)
+
+	tests := []struct {
+		name  string
+		owner []v1.OwnerReference
+	}{
+		{name: "bare pod"},
+		{name: "job", owner: controllerReference("Job", "migrate", "migrate")},
+		{name: "replicaset without deployment", owner: controllerReference("ReplicaSet", "bare-rs", "bare-rs")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := NewProbeGuardService(clientset, "system", "manager")
+
+			pod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "operator-0", Namespace: "system", OwnerReferences: test.owner}}
+			if err := p.FindOwner(context.Background(), pod); !errors.Is(err, ErrNoWorkload) {
+				t.Errorf("FindOwner() = %v, want %v", err, ErrNoWorkload)
+			}
+		})
+	}
+}
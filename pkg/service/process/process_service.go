@@ -0,0 +1,158 @@
+package process
+
+import (
+	"context"
+	"debug-me-maybe/kube"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// listProcessesScript prints one tab separated line per process visible in
+// the container: pid, whether the executable embeds Go build info, the
+// executable path and the command line.
+const listProcessesScript = `for p in /proc/[0-9]*; do
+  pid=${p#/proc/}
+  [ "$pid" = "$$" ] && continue
+  exe=$(readlink "$p/exe" 2>/dev/null) || continue
+  [ -z "$exe" ] && continue
+  if grep -q 'Go buildinf:' "$p/exe" 2>/dev/null; then go=1; else go=0; fi
+  cmdline=$(tr '\0' ' ' < "$p/cmdline" 2>/dev/null)
+  printf '%s\t%s\t%s\t%s\n' "$pid" "$go" "$exe" "$cmdline"
+done`
+
+type Process struct {
+	Pid     int
+	IsGo    bool
+	Exe     string
+	Cmdline string
+}
+
+func (p Process) String() string {
+	if p.Cmdline == "" {
+		return strconv.Itoa(p.Pid) + ": " + p.Exe
+	}
+
+	return strconv.Itoa(p.Pid) + ": " + p.Cmdline
+}
+
+// Names returns the base name of the process' executable, and of its argv[0]
+// when it differs (e.g. a binary re-executed through a symlink).
+func (p Process) Names() []string {
+	names := []string{path.Base(p.Exe)}
+
+	if fields := strings.Fields(p.Cmdline); len(fields) > 0 {
+		if argv0 := path.Base(fields[0]); argv0 != names[0] {
+			names = append(names, argv0)
+		}
+	}
+
+	return names
+}
+
+type ProcessService struct {
+	kubernetesApiService kube.KubernetesApiService
+	podName              string
+	containerName        string
+}
+
+func NewProcessService(service kube.KubernetesApiService, podName string, containerName string) *ProcessService {
+	return &ProcessService{kubernetesApiService: service, podName: podName, containerName: containerName}
+}
+
+// ListProcesses scans /proc inside the container, this requires 'sh',
+// 'readlink', 'grep' and 'tr' to be present on the container.
+func (p *ProcessService) ListProcesses(ctx context.Context) ([]Process, error) {
+	stdOut := new(kube.Writer)
+
+	command := []string{"/bin/sh", "-c", listProcessesScript}
+
+	exitCode, err := p.kubernetesApiService.ExecuteCommand(ctx, p.podName, p.containerName, command, stdOut)
+	if err != nil || exitCode != 0 {
+		return nil, errors.Errorf("failed to list processes on container: '%s', exit code: '%d'", p.containerName, exitCode)
+	}
+
+	var processes []Process
+	for _, line := range strings.Split(stdOut.Output, "\n") {
+		fields := strings.SplitN(line, "\t", 4)
+		if len(fields) != 4 {
+			continue
+		}
+
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			log.Debugf("ignoring unparsable process line: '%s'", line)
+			continue
+		}
+
+		processes = append(processes, Process{
+			Pid:     pid,
+			IsGo:    fields[1] == "1",
+			Exe:     strings.TrimSuffix(fields[2], " (deleted)"),
+			Cmdline: strings.TrimSpace(fields[3]),
+		})
+	}
+
+	sort.Slice(processes, func(i, j int) bool {
+		return processes[i].Pid < processes[j].Pid
+	})
+
+	log.Debugf("found %d process(es) on container: '%s'", len(processes), p.containerName)
+
+	return processes, nil
+}
+
+func FilterByName(processes []Process, name string) []Process {
+	var matches []Process
+	for _, process := range processes {
+		for _, processName := range process.Names() {
+			if processName == name {
+				matches = append(matches, process)
+				break
+			}
+		}
+	}
+
+	return matches
+}
+
+func FilterByExe(processes []Process, exe string) []Process {
+	var matches []Process
+	for _, process := range processes {
+		if process.Exe == exe {
+			matches = append(matches, process)
+		}
+	}
+
+	return matches
+}
+
+// FilterGo keeps the Go processes, ignoring the ones running any of the given
+// executables (e.g. a previously uploaded dlv), which may be path.Match
+// patterns.
+func FilterGo(processes []Process, ignoredExes ...string) []Process {
+	var matches []Process
+	for _, process := range processes {
+		if !process.IsGo || matchesAny(ignoredExes, process.Exe) {
+			continue
+		}
+		matches = append(matches, process)
+	}
+
+	return matches
+}
+
+// matchesAny tells whether value matches one of the path.Match patterns.
+func matchesAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+
+	return false
+}
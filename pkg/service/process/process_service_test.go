@@ -0,0 +1,41 @@
+package process
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFilterGo(t *testing.T) {
+	processes := []Process{
+		{Pid: 1, IsGo: true, Exe: "/app/server"},
+		{Pid: 7, IsGo: false, Exe: "/bin/sh"},
+		{Pid: 12, IsGo: true, Exe: "/tmp/dlv"},
+		{Pid: 13, IsGo: true, Exe: "/tmp/dmm/3f2a/dlv"},
+		{Pid: 14, IsGo: true, Exe: "/tmp/dmm/3f2a/nested/dlv"},
+	}
+
+	tests := []struct {
+		name        string
+		ignoredExes []string
+		want        []int
+	}{
+		{name: "no ignored exe", want: []int{1, 12, 13, 14}},
+		{name: "remote dlv path", ignoredExes: []string{"/tmp/dlv"}, want: []int{1, 13, 14}},
+		{name: "cached dlv pattern", ignoredExes: []string{"/tmp/dlv", "/tmp/dmm/*/dlv"}, want: []int{1, 14}},
+		{name: "no match", ignoredExes: []string{"/usr/bin/dlv"}, want: []int{1, 12, 13, 14}},
+		{name: "everything ignored", ignoredExes: []string{"/*/*", "/tmp/dmm/*/dlv", "/tmp/dmm/*/*/dlv"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var pids []int
+			for _, process := range FilterGo(processes, test.ignoredExes...) {
+				pids = append(pids, process.Pid)
+			}
+
+			if !reflect.DeepEqual(pids, test.want) {
+				t.Errorf("FilterGo(%q) = %v, want %v", test.ignoredExes, pids, test.want)
+			}
+		})
+	}
+}
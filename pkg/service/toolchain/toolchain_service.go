@@ -0,0 +1,173 @@
+package toolchain
+
+import (
+	"context"
+	"debug-me-maybe/kube"
+	"debug/buildinfo"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const delveModulePath = "github.com/go-delve/delve"
+
+// supportedGoReleases is the number of Go releases a Delve release supports:
+// the one it was released with and the previous ones.
+const supportedGoReleases = 3
+
+// alignedDelveMinor is the first Delve minor version following the Go minor
+// versions, Delve 1.9 was followed by Delve 1.20.
+const alignedDelveMinor = 20
+
+// legacyGoVersions are the Go minor versions supported by the Delve releases
+// preceding alignedDelveMinor, e.g. Delve 1.9 supports Go 1.17 to Go 1.19.
+var legacyGoVersions = map[int][2]int{
+	3: {11, 13},
+	4: {12, 14},
+	5: {13, 15},
+	6: {14, 16},
+	7: {15, 17},
+	8: {16, 18},
+	9: {17, 19},
+}
+
+type ToolchainService struct {
+	kubernetesApiService kube.KubernetesApiService
+	podName              string
+	containerName        string
+}
+
+func NewToolchainService(service kube.KubernetesApiService, podName string, containerName string) *ToolchainService {
+	return &ToolchainService{kubernetesApiService: service, podName: podName, containerName: containerName}
+}
+
+// ReadBuildInfo streams the executable of a process of the container back
+// through 'cat' and reads the build info embedded by the Go toolchain.
+func (t *ToolchainService) ReadBuildInfo(ctx context.Context, pid int) (*debug.BuildInfo, error) {
+	file, err := os.CreateTemp("", "dmm-exe-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	exe := fmt.Sprintf("/proc/%d/exe", pid)
+
+	log.Infof("reading build info of: '%s' on container: '%s'", exe, t.containerName)
+
+	exitCode, err := t.kubernetesApiService.ExecuteCommand(ctx, t.podName, t.containerName, []string{"cat", exe}, file)
+	if err != nil || exitCode != 0 {
+		return nil, errors.Errorf("failed to read: '%s' on container: '%s', exit code: '%d'", exe, t.containerName, exitCode)
+	}
+
+	info, err := buildinfo.Read(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read build info of: '%s'", exe)
+	}
+
+	return info, nil
+}
+
+// DelveVersion returns the version of Delve a local dlv binary was built from,
+// e.g. 'v1.22.1', or '(devel)' when built from a checkout.
+func DelveVersion(path string) (string, error) {
+	info, err := buildinfo.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read build info of: '%s'", path)
+	}
+
+	if info.Main.Path != delveModulePath {
+		return "", errors.Errorf("'%s' isn't built from: '%s' but from: '%s'", path, delveModulePath, info.Main.Path)
+	}
+
+	return info.Main.Version, nil
+}
+
+// SupportedGoVersions returns the range of Go minor versions supported by a
+// Delve version. Since Delve 1.20, it follows the Go releases: Delve 1.N
+// supports Go 1.N-2 to Go 1.N. Earlier releases are looked up in
+// legacyGoVersions, older or unknown ones aren't.
+func SupportedGoVersions(delveVersion string) (int, int, bool) {
+	minor, ok := parseMinor(strings.TrimPrefix(delveVersion, "v"))
+	if !ok {
+		return 0, 0, false
+	}
+
+	if minor < alignedDelveMinor {
+		versions, found := legacyGoVersions[minor]
+		return versions[0], versions[1], found
+	}
+
+	return minor - supportedGoReleases + 1, minor, true
+}
+
+// GoMinor returns the minor version of a Go version, e.g. 22 for 'go1.22.3'.
+func GoMinor(goVersion string) (int, bool) {
+	return parseMinor(strings.TrimPrefix(goVersion, "go"))
+}
+
+// Supports tells whether a Delve version supports a Go version. Versions that
+// can't be parsed are assumed to be compatible.
+func Supports(delveVersion string, goVersion string) bool {
+	minGo, maxGo, ok := SupportedGoVersions(delveVersion)
+	if !ok {
+		return true
+	}
+
+	goMinor, ok := GoMinor(goVersion)
+	if !ok {
+		return true
+	}
+
+	return goMinor >= minGo && goMinor <= maxGo
+}
+
+// DescribeSupport describes the range of Go versions supported by a Delve
+// version, for error messages.
+func DescribeSupport(delveVersion string) string {
+	minGo, maxGo, ok := SupportedGoVersions(delveVersion)
+	if !ok {
+		return delveVersion
+	}
+
+	return fmt.Sprintf("%s, go1.%d to go1.%d", delveVersion, minGo, maxGo)
+}
+
+// DescribeBuildInfo summarizes the build info of an executable for logs.
+func DescribeBuildInfo(info *debug.BuildInfo) string {
+	var settings []string
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "-gcflags", "-ldflags", "-trimpath", "CGO_ENABLED", "GOARCH", "vcs.revision":
+			settings = append(settings, setting.Key+"="+setting.Value)
+		}
+	}
+
+	return fmt.Sprintf("%s (module: '%s', version: '%s', settings: %v)", info.GoVersion, info.Main.Path, info.Main.Version, settings)
+}
+
+// parseMinor returns N of a '1.N[.P]' version.
+func parseMinor(version string) (int, bool) {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 || parts[0] != "1" {
+		return 0, false
+	}
+
+	// pre-releases, e.g. go1.23rc1
+	digits := strings.IndexFunc(parts[1], func(r rune) bool { return r < '0' || r > '9' })
+	if digits >= 0 {
+		parts[1] = parts[1][:digits]
+	}
+
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, false
+	}
+
+	return minor, true
+}
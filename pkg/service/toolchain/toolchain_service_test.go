@@ -0,0 +1,58 @@
+package toolchain
+
+import "testing"
+
+func TestSupportedGoVersions(t *testing.T) {
+	tests := []struct {
+		delveVersion string
+		minGo        int
+		maxGo        int
+		ok           bool
+	}{
+		{delveVersion: "v1.22.1", minGo: 20, maxGo: 22, ok: true},
+		{delveVersion: "v1.20.0", minGo: 18, maxGo: 20, ok: true},
+		{delveVersion: "v1.9.1", minGo: 17, maxGo: 19, ok: true},
+		{delveVersion: "v1.7.3", minGo: 15, maxGo: 17, ok: true},
+		{delveVersion: "v1.2.0", ok: false},
+		{delveVersion: "v1.15.0", ok: false},
+		{delveVersion: "(devel)", ok: false},
+		{delveVersion: "", ok: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.delveVersion, func(t *testing.T) {
+			minGo, maxGo, ok := SupportedGoVersions(test.delveVersion)
+			if minGo != test.minGo || maxGo != test.maxGo || ok != test.ok {
+				t.Errorf("SupportedGoVersions(%q) = %d, %d, %t, want %d, %d, %t",
+					test.delveVersion, minGo, maxGo, ok, test.minGo, test.maxGo, test.ok)
+			}
+		})
+	}
+}
+
+func TestSupports(t *testing.T) {
+	tests := []struct {
+		delveVersion string
+		goVersion    string
+		supported    bool
+	}{
+		{delveVersion: "v1.22.1", goVersion: "go1.22.3", supported: true},
+		{delveVersion: "v1.22.1", goVersion: "go1.20", supported: true},
+		{delveVersion: "v1.22.1", goVersion: "go1.23rc1", supported: false},
+		{delveVersion: "v1.22.1", goVersion: "go1.19.13", supported: false},
+		{delveVersion: "v1.9.1", goVersion: "go1.19.2", supported: true},
+		{delveVersion: "v1.9.1", goVersion: "go1.17", supported: true},
+		{delveVersion: "v1.9.1", goVersion: "go1.9", supported: false},
+		{delveVersion: "v1.9.1", goVersion: "go1.20", supported: false},
+		{delveVersion: "(devel)", goVersion: "go1.22.3", supported: true},
+		{delveVersion: "v1.22.1", goVersion: "devel go1.24-abcdef", supported: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.delveVersion+"/"+test.goVersion, func(t *testing.T) {
+			if supported := Supports(test.delveVersion, test.goVersion); supported != test.supported {
+				t.Errorf("Supports(%q, %q) = %t, want %t", test.delveVersion, test.goVersion, supported, test.supported)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Registry records the debug sessions of a kubeconfig context. Pod annotations
+// are the source of truth, the local store keeps track of the sessions the pod
+// couldn't be annotated for and lets the sessions be found without listing the
+// pods of every namespace.
+type Registry struct {
+	clientset *kubernetes.Clientset
+	store     *Store
+	context   string
+}
+
+func NewRegistry(clientset *kubernetes.Clientset, context string) (*Registry, error) {
+	store, err := NewStore()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Registry{clientset: clientset, store: store, context: context}, nil
+}
+
+func (r *Registry) Register(ctx context.Context, s Session) error {
+	s.Context = r.context
+
+	if err := r.store.Add(s); err != nil {
+		return err
+	}
+
+	value, err := s.AnnotationValue()
+	if err != nil {
+		return err
+	}
+
+	if err := r.patchAnnotation(ctx, s, &value); err != nil {
+		log.WithError(err).Warnf("failed to record session: '%s' on pod: '%s', it is only recorded locally", s.ID, s.Pod)
+	}
+
+	log.Infof("session '%s' registered", s.ID)
+
+	return nil
+}
+
+// Refresh records the changes of a registered session, e.g. its expiry.
+func (r *Registry) Refresh(ctx context.Context, s Session) error {
+	s.Context = r.context
+
+	if err := r.store.Add(s); err != nil {
+		return err
+	}
+
+	value, err := s.AnnotationValue()
+	if err != nil {
+		return err
+	}
+
+	return r.patchAnnotation(ctx, s, &value)
+}
+
+func (r *Registry) Unregister(ctx context.Context, s Session) error {
+	if err := r.patchAnnotation(ctx, s, nil); err != nil && !k8serrors.IsNotFound(err) {
+		log.WithError(err).Warnf("failed to remove session: '%s' from pod: '%s'", s.ID, s.Pod)
+	}
+
+	if err := r.store.Remove(s.ID); err != nil {
+		return err
+	}
+
+	log.Infof("session '%s' unregistered", s.ID)
+
+	return nil
+}
+
+// patchAnnotation sets the session annotation of the pod, or removes it when
+// value is nil.
+func (r *Registry) patchAnnotation(ctx context.Context, s Session, value *string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]*string{
+				s.AnnotationKey(): value,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.clientset.CoreV1().Pods(s.Namespace).Patch(ctx, s.Pod, types.MergePatchType, patch, v1.PatchOptions{})
+
+	return err
+}
+
+// List returns the sessions of the namespace, or of all namespaces when
+// namespace is empty. Locally recorded sessions of pods that don't exist
+// anymore are forgotten.
+func (r *Registry) List(ctx context.Context, namespace string) ([]Session, error) {
+	pods, err := r.clientset.CoreV1().Pods(namespace).List(ctx, v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := map[string]Session{}
+	existingPods := map[types.NamespacedName]bool{}
+
+	for _, pod := range pods.Items {
+		existingPods[types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}] = true
+
+		for _, s := range FromAnnotations(pod.Annotations) {
+			s.Context = r.context
+			s.Namespace = pod.Namespace
+			s.Pod = pod.Name
+			sessions[s.ID] = s
+		}
+	}
+
+	localSessions, err := r.store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range localSessions {
+		if s.Context != r.context || (namespace != "" && s.Namespace != namespace) {
+			continue
+		}
+
+		if _, found := sessions[s.ID]; found {
+			continue
+		}
+
+		if !existingPods[types.NamespacedName{Namespace: s.Namespace, Name: s.Pod}] {
+			log.Debugf("forgetting session: '%s', pod: '%s' doesn't exist anymore", s.ID, s.Pod)
+			if err := r.store.Remove(s.ID); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		sessions[s.ID] = s
+	}
+
+	var result []Session
+	for _, s := range sessions {
+		result = append(result, s)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartTime.Before(result[j].StartTime)
+	})
+
+	return result, nil
+}
+
+// Get finds a session by ID, looking it up in the local store first and in the
+// pods of every namespace otherwise.
+func (r *Registry) Get(ctx context.Context, id string) (Session, error) {
+	localSessions, err := r.store.Load()
+	if err != nil {
+		return Session{}, err
+	}
+
+	for _, s := range localSessions {
+		if s.ID != id || s.Context != r.context {
+			continue
+		}
+
+		_, err := r.clientset.CoreV1().Pods(s.Namespace).Get(ctx, s.Pod, v1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			_ = r.store.Remove(s.ID)
+			return Session{}, errors.Errorf("pod: '%s' of session: '%s' doesn't exist anymore", s.Pod, id)
+		}
+		if err != nil {
+			return Session{}, err
+		}
+
+		return s, nil
+	}
+
+	sessions, err := r.List(ctx, corev1.NamespaceAll)
+	if err != nil {
+		return Session{}, err
+	}
+
+	for _, s := range sessions {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+
+	return Session{}, errors.Errorf("session: '%s' not found", id)
+}
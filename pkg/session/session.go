@@ -0,0 +1,94 @@
+package session
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/rand"
+)
+
+// AnnotationPrefix prefixes the pod annotations recording the debug sessions
+// of a pod, the annotation key ends with the session ID.
+const AnnotationPrefix = "debug-me-maybe/session-"
+
+// DefaultExpiry is how long a detached session, its debugger running without
+// dmm, is kept before it is considered abandoned, for its debugger to be
+// garbage collected.
+const DefaultExpiry = 24 * time.Hour
+
+// HeartbeatInterval is how often dmm pushes back the expiry of the session it
+// is attached to, by HeartbeatExpiry, so that the session of a dmm that was
+// killed expires shortly after.
+const HeartbeatInterval = time.Minute
+
+const HeartbeatExpiry = 5 * time.Minute
+
+type Session struct {
+	ID                string    `json:"id"`
+	Context           string    `json:"context"`
+	Namespace         string    `json:"namespace"`
+	Pod               string    `json:"pod"`
+	Container         string    `json:"container"`
+	DebuggerContainer string    `json:"debuggerContainer,omitempty"`
+	Pid               int       `json:"pid"`
+	RemoteDlvPath     string    `json:"remoteDlvPath"`
+	DlvPath           string    `json:"dlvPath,omitempty"`
+	DebuggerPort      int       `json:"debuggerPort"`
+	StartTime         time.Time `json:"startTime"`
+	ExpiresAt         time.Time `json:"expiresAt"`
+	Owner             string    `json:"owner,omitempty"`
+}
+
+func NewSessionId() string {
+	return rand.String(8)
+}
+
+// Expired tells whether the session outlived its expiry. Sessions recorded
+// before expiries were don't expire.
+func (s Session) Expired(now time.Time) bool {
+	return !s.ExpiresAt.IsZero() && now.After(s.ExpiresAt)
+}
+
+// SharesDlv tells whether another session runs the same uploaded dlv binary,
+// in the same container of the same pod.
+func (s Session) SharesDlv(other Session) bool {
+	return other.ID != s.ID && other.Namespace == s.Namespace && other.Pod == s.Pod &&
+		other.DebuggerContainer == s.DebuggerContainer && other.DlvPath == s.DlvPath
+}
+
+func (s Session) AnnotationKey() string {
+	return AnnotationPrefix + s.ID
+}
+
+// AnnotationValue serializes the session for the pod annotation, the context
+// being local to the user it isn't recorded.
+func (s Session) AnnotationValue() (string, error) {
+	s.Context = ""
+
+	value, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+
+	return string(value), nil
+}
+
+// FromAnnotations returns the sessions recorded in the annotations of a pod.
+func FromAnnotations(annotations map[string]string) []Session {
+	var sessions []Session
+	for key, value := range annotations {
+		if !strings.HasPrefix(key, AnnotationPrefix) {
+			continue
+		}
+
+		var s Session
+		if err := json.Unmarshal([]byte(value), &s); err != nil {
+			continue
+		}
+
+		sessions = append(sessions, s)
+	}
+
+	return sessions
+}
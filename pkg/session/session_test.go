@@ -0,0 +1,116 @@
+package session
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAnnotationRoundTrip(t *testing.T) {
+	startTime := time.Date(2023, 3, 14, 9, 26, 53, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		session Session
+	}{
+		{name: "attached", session: Session{ID: "x7k2p9qa", Context: "prod", Namespace: "shop", Pod: "cart-5d8f",
+			Container: "cart", Pid: 1, RemoteDlvPath: "/tmp/dlv", DlvPath: "/tmp/dmm/3f2a/dlv", DebuggerPort: 2345,
+			StartTime: startTime, ExpiresAt: startTime.Add(5 * time.Minute), Owner: "jane"}},
+		{name: "ephemeral", session: Session{ID: "b4n8m2zt", Namespace: "shop", Pod: "cart-5d8f", Container: "cart",
+			DebuggerContainer: "dmm-debugger-q2w4e", Pid: 12, RemoteDlvPath: "/dev/shm/dlv", DebuggerPort: 40000,
+			StartTime: startTime, ExpiresAt: startTime.Add(DefaultExpiry)}},
+		{name: "no expiry", session: Session{ID: "c9v3l5rd", Namespace: "shop", Pod: "cart-5d8f", Container: "cart",
+			Pid: 1, RemoteDlvPath: "/tmp/dlv", DebuggerPort: 2345, StartTime: startTime}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, err := test.session.AnnotationValue()
+			if err != nil {
+				t.Fatalf("AnnotationValue() error = %v", err)
+			}
+
+			annotations := map[string]string{
+				test.session.AnnotationKey():        value,
+				"kubectl.kubernetes.io/restartedAt": "2023-03-14T09:00:00Z",
+			}
+
+			want := test.session
+			want.Context = ""
+
+			got := FromAnnotations(annotations)
+			if len(got) != 1 || !reflect.DeepEqual(got[0], want) {
+				t.Errorf("FromAnnotations(%q) = %+v, want [%+v]", annotations, got, want)
+			}
+		})
+	}
+}
+
+func TestFromAnnotationsSkipsInvalid(t *testing.T) {
+	annotations := map[string]string{
+		AnnotationPrefix + "broken": "{",
+		AnnotationPrefix + "valid":  `{"id":"valid","pod":"cart-5d8f"}`,
+		"debug-me-maybe/other":      `{"id":"other"}`,
+	}
+
+	got := FromAnnotations(annotations)
+	if len(got) != 1 || got[0].ID != "valid" {
+		t.Errorf("FromAnnotations(%q) = %+v, want the valid session only", annotations, got)
+	}
+}
+
+func TestExpired(t *testing.T) {
+	expiresAt := time.Date(2023, 3, 14, 9, 26, 53, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		expiresAt time.Time
+		now       time.Time
+		want      bool
+	}{
+		{name: "before expiry", expiresAt: expiresAt, now: expiresAt.Add(-time.Second), want: false},
+		{name: "at expiry", expiresAt: expiresAt, now: expiresAt, want: false},
+		{name: "after expiry", expiresAt: expiresAt, now: expiresAt.Add(time.Second), want: true},
+		{name: "no expiry", now: expiresAt.Add(365 * 24 * time.Hour), want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := Session{ExpiresAt: test.expiresAt}
+			if got := s.Expired(test.now); got != test.want {
+				t.Errorf("Expired(%v) = %t, want %t", test.now, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSharesDlv(t *testing.T) {
+	s := Session{ID: "x7k2p9qa", Namespace: "shop", Pod: "cart-5d8f", Container: "cart", DlvPath: "/tmp/dmm/3f2a/dlv"}
+
+	tests := []struct {
+		name   string
+		other  Session
+		shared bool
+	}{
+		{name: "same dlv", shared: true,
+			other: Session{ID: "b4n8m2zt", Namespace: "shop", Pod: "cart-5d8f", Container: "cart", DlvPath: "/tmp/dmm/3f2a/dlv"}},
+		{name: "itself", other: s},
+		{name: "other dlv",
+			other: Session{ID: "b4n8m2zt", Namespace: "shop", Pod: "cart-5d8f", Container: "cart", DlvPath: "/tmp/dmm/9c1d/dlv"}},
+		{name: "other pod",
+			other: Session{ID: "b4n8m2zt", Namespace: "shop", Pod: "cart-7b2c", Container: "cart", DlvPath: "/tmp/dmm/3f2a/dlv"}},
+		{name: "other namespace",
+			other: Session{ID: "b4n8m2zt", Namespace: "staging", Pod: "cart-5d8f", Container: "cart", DlvPath: "/tmp/dmm/3f2a/dlv"}},
+		{name: "debugger container",
+			other: Session{ID: "b4n8m2zt", Namespace: "shop", Pod: "cart-5d8f", Container: "cart",
+				DebuggerContainer: "dmm-debugger-q2w4e", DlvPath: "/tmp/dmm/3f2a/dlv"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if shared := s.SharesDlv(test.other); shared != test.shared {
+				t.Errorf("SharesDlv(%+v) = %t, want %t", test.other, shared, test.shared)
+			}
+		})
+	}
+}
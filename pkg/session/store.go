@@ -0,0 +1,86 @@
+package session
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// Store keeps track of the sessions started by the user in a local file.
+type Store struct {
+	path string
+}
+
+func NewStore() (*Store, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{path: filepath.Join(configDir, "debug-me-maybe", "sessions.json")}, nil
+}
+
+func (s *Store) Load() ([]Session, error) {
+	content, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []Session
+	if err := json.Unmarshal(content, &sessions); err != nil {
+		return nil, errors.Wrapf(err, "failed to read session store: '%s'", s.path)
+	}
+
+	return sessions, nil
+}
+
+func (s *Store) Save(sessions []Session) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+
+	content, err := json.MarshalIndent(sessions, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(s.path, content, 0600)
+}
+
+// Add records a session, replacing the one with the same ID.
+func (s *Store) Add(session Session) error {
+	sessions, err := s.Load()
+	if err != nil {
+		return err
+	}
+
+	for i := range sessions {
+		if sessions[i].ID == session.ID {
+			sessions[i] = session
+			return s.Save(sessions)
+		}
+	}
+
+	return s.Save(append(sessions, session))
+}
+
+func (s *Store) Remove(id string) error {
+	sessions, err := s.Load()
+	if err != nil {
+		return err
+	}
+
+	var kept []Session
+	for _, session := range sessions {
+		if session.ID != id {
+			kept = append(kept, session)
+		}
+	}
+
+	return s.Save(kept)
+}